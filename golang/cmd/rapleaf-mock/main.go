@@ -0,0 +1,57 @@
+package main
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// rapleaf-mock serves person and graph lookups from a directory of fixture
+// files, e.g.
+//
+//   fixtures/v3/person/email/john.q.public@gmail.com.xml
+//   fixtures/v3/person/web/linkedin/johnqpublic.xml
+//   fixtures/v2/graph/john.q.public@gmail.com.txt
+//
+// Point a client at it with rapleaf.OverrideRapleafHostPort.
+
+import (
+  "rapleaf"
+  "flag"
+  "fmt"
+  "http"
+  "os"
+)
+
+var (
+  addr = flag.String("addr", ":8080", "address to listen on")
+  dir = flag.String("dir", "fixtures", "directory of .xml, .json and .txt fixture files")
+  api_key = flag.String("api-key", "", "API key callers must send; empty accepts any")
+  quota = flag.Int("quota", 0, "answer 403 after this many calls; 0 for no limit")
+  pending = flag.Int("pending", 0, "answer 202 to the first this many calls for each lookup")
+)
+
+func main() {
+  flag.Parse()
+  server := rapleaf.NewMockServer(*api_key)
+  server.Quota = *quota
+  server.Pending = *pending
+  if err := server.LoadFixtures(*dir); err != nil {
+    fmt.Fprintf(os.Stderr, "rapleaf-mock: %s\n", err.String())
+    os.Exit(1)
+  }
+  if err := http.ListenAndServe(*addr, server); err != nil {
+    fmt.Fprintf(os.Stderr, "rapleaf-mock: %s\n", err.String())
+    os.Exit(1)
+  }
+}
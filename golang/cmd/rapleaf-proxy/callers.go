@@ -0,0 +1,127 @@
+package main
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "bufio"
+  "os"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+// A caller is one client service.  Callers authenticate to the proxy with
+// their own key and never see the upstream Rapleaf key.
+type caller struct {
+  name string
+  // quota is the number of upstream lookups the caller may cause; zero
+  // means no limit.  Cache hits and coalesced requests are free.
+  quota int64
+  requests int64
+  hits int64
+  misses int64
+  rejected int64
+}
+
+type callers struct {
+  byKey map[string]*caller
+  lock sync.Mutex
+}
+
+// readCallers reads lines of "<caller key> <name> [quota]"; blank lines and
+// lines starting with '#' are ignored.
+func readCallers(filename string) (*callers, os.Error) {
+  f, err := os.Open(filename, os.O_RDONLY, 0)
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+  p := &callers{byKey:make(map[string]*caller)}
+  r := bufio.NewReader(f)
+  for lineno := 1; ; lineno++ {
+    line, err := r.ReadString('\n')
+    if err != nil && err != os.EOF {
+      return nil, err
+    }
+    fields := strings.Fields(line)
+    if len(fields) > 0 && !strings.HasPrefix(fields[0], "#") {
+      if len(fields) < 2 || len(fields) > 3 {
+        return nil, os.NewError(filename + ":" + strconv.Itoa(lineno) + ": expected <caller key> <name> [quota]")
+      }
+      c := &caller{name:fields[1]}
+      if len(fields) == 3 {
+        if c.quota, err = strconv.Atoi64(fields[2]); err != nil {
+          return nil, os.NewError(filename + ":" + strconv.Itoa(lineno) + ": bad quota " + strconv.Quote(fields[2]))
+        }
+      }
+      p.byKey[fields[0]] = c
+    }
+    if err == os.EOF {
+      break
+    }
+  }
+  return p, nil
+}
+
+func (p *callers) lookup(key string) *caller {
+  p.lock.Lock()
+  defer p.lock.Unlock()
+  return p.byKey[key]
+}
+
+// hit counts a request answered from the cache or by joining a lookup
+// already in flight.
+func (p *callers) hit(c *caller) {
+  p.lock.Lock()
+  defer p.lock.Unlock()
+  c.requests++
+  c.hits++
+}
+
+// charge counts a request that needs an upstream lookup and reports whether
+// the caller still has quota for it.
+func (p *callers) charge(c *caller) bool {
+  p.lock.Lock()
+  defer p.lock.Unlock()
+  c.requests++
+  if c.quota > 0 && c.misses >= c.quota {
+    c.rejected++
+    return false
+  }
+  c.misses++
+  return true
+}
+
+func (p *callers) String() string {
+  p.lock.Lock()
+  defer p.lock.Unlock()
+  lines := make([]string, len(p.byKey) + 1)
+  lines[0] = "caller\trequests\thits\tmisses\trejected\tquota"
+  i := 1
+  for _, c := range p.byKey {
+    lines[i] = strings.Join([]string{
+      c.name,
+      strconv.Itoa64(c.requests),
+      strconv.Itoa64(c.hits),
+      strconv.Itoa64(c.misses),
+      strconv.Itoa64(c.rejected),
+      strconv.Itoa64(c.quota),
+    }, "\t")
+    i++
+  }
+  return strings.Join(lines, "\n") + "\n"
+}
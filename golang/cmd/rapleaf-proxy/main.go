@@ -0,0 +1,70 @@
+package main
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// rapleaf-proxy answers the Rapleaf person and graph API paths for a fleet
+// of services, sharing one upstream API key, one cache and one quota among
+// them.  Services authenticate with their own keys, listed in the callers
+// file, and need only point rapleaf.OverrideRapleafHostPort at the proxy.
+// Per-caller usage is reported at /stats and upstream usage, in the
+// Prometheus text format, at /metrics; both need a caller key too.
+
+import (
+  "rapleaf"
+  "flag"
+  "fmt"
+  "http"
+  "os"
+)
+
+var (
+  addr = flag.String("addr", ":8080", "address to listen on")
+  api_key = flag.String("api-key", "", "upstream Rapleaf API key (defaults to $RAPLEAF_API_KEY)")
+  callers_file = flag.String("callers", "callers.txt", "file of \"<caller key> <name> [quota]\" lines")
+  ttl = flag.Int64("ttl", 24 * 60 * 60, "seconds to cache a response")
+  daily_quota = flag.Int64("daily-quota", 0, "upstream lookups per day the API key allows, for /metrics projections")
+  max_entries = flag.Int("cache-size", 100000, "maximum number of cached responses")
+  upstream_host = flag.String("upstream-host", "", "override the upstream Rapleaf API host")
+  upstream_port = flag.String("upstream-port", "80", "override the upstream Rapleaf API port")
+)
+
+func fatal(err os.Error) {
+  fmt.Fprintf(os.Stderr, "rapleaf-proxy: %s\n", err.String())
+  os.Exit(1)
+}
+
+func main() {
+  flag.Parse()
+  key := *api_key
+  if len(key) == 0 {
+    key = os.Getenv("RAPLEAF_API_KEY")
+  }
+  if len(key) == 0 {
+    fatal(os.NewError("no API key; use --api-key or set RAPLEAF_API_KEY"))
+  }
+  if len(*upstream_host) > 0 {
+    rapleaf.OverrideRapleafHostPort(*upstream_host, *upstream_port)
+  }
+  rapleaf.SetDailyQuota(*daily_quota)
+  c, err := readCallers(*callers_file)
+  if err != nil {
+    fatal(err)
+  }
+  if err = http.ListenAndServe(*addr, newProxy(key, *ttl * 1e9, *max_entries, c)); err != nil {
+    fatal(err)
+  }
+}
@@ -0,0 +1,206 @@
+package main
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "rapleaf"
+  "http"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+type response struct {
+  code int
+  text string
+  expires int64
+}
+
+// A call is an upstream lookup in flight.  Requests for the same lookup
+// that arrive meanwhile wait on done instead of spending another query.
+type call struct {
+  done chan bool
+  resp *response
+}
+
+type proxy struct {
+  api_key string
+  ttl int64
+  max_entries int
+  callers *callers
+  cache map[string]*response
+  inflight map[string]*call
+  lock sync.Mutex
+}
+
+func newProxy(api_key string, ttl int64, max_entries int, c *callers) *proxy {
+  return &proxy{
+    api_key:api_key,
+    ttl:ttl,
+    max_entries:max_entries,
+    callers:c,
+    cache:make(map[string]*response),
+    inflight:make(map[string]*call),
+  }
+}
+
+// cacheable reports whether a status is an answer about the person rather
+// than about the request, the key or the health of the upstream API.
+func cacheable(code int) bool {
+  return code == http.StatusOK || code == http.StatusNotFound
+}
+
+// route maps a request onto the package lookup it stands for, returning the
+// cache key and the function performing the lookup, or a nil function if
+// the path is not one the Rapleaf API serves.  Keys are built from the
+// lowercased email and the site's short name, so spellings the API treats
+// alike share an entry.
+func (p *proxy) route(req *http.Request, tag rapleaf.CallerTag) (string, func() (int, string)) {
+  path := req.URL.Path
+  switch {
+  case strings.HasPrefix(path, "/v3/person/email/"):
+    email := strings.ToLower(path[len("/v3/person/email/"):])
+    return "/v3/person/email/" + email, func() (int, string) {
+      return tag.PersonXmlByEmail(p.api_key, email)
+    }
+  case strings.HasPrefix(path, "/v3/person/web/"):
+    rest := path[len("/v3/person/web/"):]
+    i := strings.Index(rest, "/")
+    if i <= 0 || i == len(rest) - 1 {
+      return path, nil
+    }
+    site, profile_id := rest[0:i], rest[i + 1:]
+    key := "/v3/person/web/" + rapleaf.NormalizeSite(site).ShortName() + "/" + profile_id
+    return key, func() (int, string) {
+      return tag.PersonXmlBySite(p.api_key, site, profile_id)
+    }
+  case strings.HasPrefix(path, "/v2/graph/"):
+    email_or_rapleaf_id := path[len("/v2/graph/"):]
+    n := 1
+    if query, err := http.ParseQuery(req.URL.RawQuery); err == nil {
+      if values, ok := query["n"]; ok && len(values) > 0 {
+        if n, err = strconv.Atoi(values[0]); err != nil {
+          return path, nil
+        }
+      }
+    }
+    return path + "?n=" + strconv.Itoa(n), func() (int, string) {
+      return tag.GraphTextByEmailOrRapleafId(p.api_key, email_or_rapleaf_id, n)
+    }
+  }
+  return path, nil
+}
+
+// store caches resp under key, first dropping expired entries if the cache
+// is full.  Must be called with p.lock held.
+func (p *proxy) store(key string, resp *response) {
+  if p.max_entries <= 0 {
+    return
+  }
+  if len(p.cache) >= p.max_entries {
+    now := time.Nanoseconds()
+    for k, r := range p.cache {
+      if r.expires <= now {
+        p.cache[k] = nil, false
+      }
+    }
+  }
+  if len(p.cache) < p.max_entries {
+    p.cache[key] = resp
+  }
+}
+
+func writeResponse(conn *http.Conn, path string, resp *response) {
+  text := []byte(resp.text)
+  switch {
+  case resp.code != http.StatusOK:
+    conn.SetHeader("Content-Type", "text/html;charset=ISO-8859-1")
+    conn.SetHeader("Cache-Control", "must-revalidate,no-cache,no-store")
+  case strings.HasPrefix(path, "/v2/graph/"):
+    conn.SetHeader("Content-Type", "text/plain;charset=UTF-8")
+  default:
+    conn.SetHeader("Content-Type", "application/xml;charset=UTF-8")
+  }
+  conn.SetHeader("Content-Length", strconv.Itoa(len(text)))
+  conn.WriteHeader(resp.code)
+  conn.Write(text)
+  conn.Flush()
+}
+
+func writeStatus(conn *http.Conn, path string, code int) {
+  writeResponse(conn, path, &response{code:code, text:rapleaf.ERROR_CODES[code]})
+}
+
+func (p *proxy) ServeHTTP(conn *http.Conn, req *http.Request) {
+  path := req.URL.Path
+  c := p.callers.lookup(req.Header["Authorization"])
+  if c == nil {
+    writeStatus(conn, path, http.StatusUnauthorized)
+    return
+  }
+  if path == "/metrics" {
+    rapleaf.UsageHandler(conn, req)
+    return
+  }
+  if path == "/stats" {
+    conn.SetHeader("Content-Type", "text/plain;charset=UTF-8")
+    conn.WriteHeader(http.StatusOK)
+    conn.Write([]byte(p.callers.String()))
+    return
+  }
+  key, fetch := p.route(req, rapleaf.CallerTag(c.name))
+  if fetch == nil {
+    writeStatus(conn, path, http.StatusBadRequest)
+    return
+  }
+  p.lock.Lock()
+  if resp, ok := p.cache[key]; ok && resp.expires > time.Nanoseconds() {
+    p.lock.Unlock()
+    p.callers.hit(c)
+    rapleaf.RecordCacheLookup(true)
+    writeResponse(conn, path, resp)
+    return
+  }
+  if cl, ok := p.inflight[key]; ok {
+    p.lock.Unlock()
+    p.callers.hit(c)
+    rapleaf.RecordCacheLookup(true)
+    <-cl.done
+    writeResponse(conn, path, cl.resp)
+    return
+  }
+  if !p.callers.charge(c) {
+    p.lock.Unlock()
+    writeStatus(conn, path, http.StatusForbidden)
+    return
+  }
+  cl := &call{done:make(chan bool)}
+  p.inflight[key] = cl
+  p.lock.Unlock()
+  rapleaf.RecordCacheLookup(false)
+  code, text := fetch()
+  cl.resp = &response{code:code, text:text, expires:time.Nanoseconds() + p.ttl}
+  p.lock.Lock()
+  p.inflight[key] = nil, false
+  if cacheable(code) {
+    p.store(key, cl.resp)
+  }
+  p.lock.Unlock()
+  close(cl.done)
+  writeResponse(conn, path, cl.resp)
+}
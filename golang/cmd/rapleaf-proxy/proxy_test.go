@@ -0,0 +1,302 @@
+package main
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+import (
+  "http"
+  "io/ioutil"
+  "net"
+  "os"
+  "rand"
+  "rapleaf"
+  "strconv"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+)
+
+const (
+  CALLER_KEY = "caller-key"
+  UPSTREAM_KEY = "upstream-key"
+  PERSON_XML = "<?xml version=\"1.0\" encoding=\"UTF-8\"?><person id=\"97fc425100000000\"><basics><name>John Q Public</name></basics><memberships><primary></primary><supplemental></supplemental></memberships></person>"
+)
+
+// serveTestProxy starts p on a random local port, as serveTestHandler does
+// for the rapleaf package tests, and returns the listener and its address.
+func serveTestProxy(t *testing.T, p http.Handler) (net.Listener, string) {
+  for {
+    port_str := strconv.Itoa((rand.Int() & 0x7FFF) + 0x08000)
+    addr, err := net.ResolveTCPAddr("127.0.0.1:" + port_str)
+    if err != nil {
+      t.Fatal("Create TCP Address: ", err.String())
+    }
+    l, err := net.ListenTCP("tcp4", addr)
+    if err != nil {
+      if err == os.EADDRINUSE || strings.LastIndex(err.String(), os.EADDRINUSE.String()) != -1 {
+        continue
+      }
+      t.Fatal("Unable to listen on TCP port: ", err.String())
+    }
+    go http.Serve(l, p)
+    return l, "127.0.0.1:" + port_str
+  }
+  panic("unreachable")
+}
+
+func testGet(t *testing.T, addr, path, key string) (int, string) {
+  headers := make(map[string]string)
+  if len(key) > 0 {
+    headers["Authorization"] = key
+  }
+  url := "http://" + addr + path
+  parsedUrl, err := http.ParseURL(url)
+  if err != nil {
+    t.Fatal(err.String())
+  }
+  req := &http.Request{Method:"GET",
+    RawURL:url,
+    URL:parsedUrl,
+    Proto:"HTTP/1.1",
+    ProtoMajor:1,
+    ProtoMinor:1,
+    Header:headers,
+    Host:addr,
+  }
+  c, err := net.Dial("tcp", "", addr)
+  if err != nil {
+    t.Fatal(err.String())
+  }
+  conn := http.NewClientConn(c, nil)
+  if err = conn.Write(req); err != nil {
+    t.Fatal(err.String())
+  }
+  resp, err := conn.Read()
+  if resp == nil {
+    t.Fatal("no response for ", path)
+  }
+  buf, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    t.Fatal(err.String())
+  }
+  return resp.StatusCode, string(buf)
+}
+
+func newTestProxy() *proxy {
+  c := &callers{byKey:make(map[string]*caller)}
+  c.byKey[CALLER_KEY] = &caller{name:"billing"}
+  return newProxy(UPSTREAM_KEY, 60 * 1e9, 10, c)
+}
+
+// serveUpstream starts h on a random local port and points the rapleaf
+// package at it.
+func serveUpstream(t *testing.T, h http.Handler) net.Listener {
+  l, addr := serveTestProxy(t, h)
+  rapleaf.OverrideRapleafHostPort("127.0.0.1", addr[len("127.0.0.1:"):])
+  return l
+}
+
+func newTestUpstream() *rapleaf.MockServer {
+  server := rapleaf.NewMockServer(UPSTREAM_KEY)
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "application/xml;charset=UTF-8", PERSON_XML)
+  server.AddFixture("/v3/person/web/twitter/johnqpublic", "application/xml;charset=UTF-8", PERSON_XML)
+  return server
+}
+
+func TestReportsNeedCallerKey(t *testing.T) {
+  l, addr := serveTestProxy(t, newTestProxy())
+  defer l.Close()
+  for _, path := range []string{"/stats", "/metrics"} {
+    if code, _ := testGet(t, addr, path, ""); code != http.StatusUnauthorized {
+      t.Errorf("%s without a key: expected status %d, got %d", path, http.StatusUnauthorized, code)
+    }
+    if code, _ := testGet(t, addr, path, "not-a-caller"); code != http.StatusUnauthorized {
+      t.Errorf("%s with an unknown key: expected status %d, got %d", path, http.StatusUnauthorized, code)
+    }
+  }
+}
+
+func TestStatsForCaller(t *testing.T) {
+  l, addr := serveTestProxy(t, newTestProxy())
+  defer l.Close()
+  code, text := testGet(t, addr, "/stats", CALLER_KEY)
+  if code != http.StatusOK {
+    t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+  }
+  if strings.Index(text, "billing\t") < 0 {
+    t.Errorf("expected a line for caller billing, got %q", text)
+  }
+  if code, _ = testGet(t, addr, "/metrics", CALLER_KEY); code != http.StatusOK {
+    t.Errorf("/metrics: expected status %d, got %d", http.StatusOK, code)
+  }
+}
+
+func TestCacheExpires(t *testing.T) {
+  server := newTestUpstream()
+  up := serveUpstream(t, server)
+  defer up.Close()
+  p := newTestProxy()
+  p.ttl = 50e6
+  l, addr := serveTestProxy(t, p)
+  defer l.Close()
+  for i := 0; i < 2; i++ {
+    if code, _ := testGet(t, addr, "/v3/person/email/john.q.public@gmail.com", CALLER_KEY); code != http.StatusOK {
+      t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+    }
+  }
+  if server.Calls() != 1 {
+    t.Errorf("expected the second lookup from the cache, got %d upstream calls", server.Calls())
+  }
+  time.Sleep(100e6)
+  testGet(t, addr, "/v3/person/email/john.q.public@gmail.com", CALLER_KEY)
+  if server.Calls() != 2 {
+    t.Errorf("expected an upstream call once the entry expired, got %d upstream calls", server.Calls())
+  }
+}
+
+func TestCacheKeysNormalized(t *testing.T) {
+  server := newTestUpstream()
+  up := serveUpstream(t, server)
+  defer up.Close()
+  l, addr := serveTestProxy(t, newTestProxy())
+  defer l.Close()
+  paths := []string{
+    "/v3/person/email/john.q.public@gmail.com",
+    "/v3/person/email/John.Q.Public@Gmail.com",
+    "/v3/person/web/twitter/johnqpublic",
+    "/v3/person/web/www.Twitter.com/johnqpublic",
+  }
+  for _, path := range paths {
+    if code, _ := testGet(t, addr, path, CALLER_KEY); code != http.StatusOK {
+      t.Errorf("%s: expected status %d, got %d", path, http.StatusOK, code)
+    }
+  }
+  if server.Calls() != 2 {
+    t.Errorf("expected one upstream call per person, got %d", server.Calls())
+  }
+}
+
+func TestConcurrentLookupsCoalesced(t *testing.T) {
+  server := newTestUpstream()
+  started := make(chan bool, 1)
+  release := make(chan bool)
+  up := serveUpstream(t, http.HandlerFunc(func(conn *http.Conn, req *http.Request) {
+    started <- true
+    <-release
+    server.ServeHTTP(conn, req)
+  }))
+  defer up.Close()
+  p := newTestProxy()
+  l, addr := serveTestProxy(t, p)
+  defer l.Close()
+  const n = 3
+  codes := make(chan int, n)
+  get := func() {
+    code, _ := testGet(t, addr, "/v3/person/email/john.q.public@gmail.com", CALLER_KEY)
+    codes <- code
+  }
+  go get()
+  <-started
+  for i := 1; i < n; i++ {
+    go get()
+  }
+  // the others join the lookup in flight once they are counted as hits
+  c := p.callers.lookup(CALLER_KEY)
+  for i := 0; i < 100; i++ {
+    p.callers.lock.Lock()
+    hits := c.hits
+    p.callers.lock.Unlock()
+    if hits == n - 1 {
+      break
+    }
+    time.Sleep(10e6)
+  }
+  close(release)
+  for i := 0; i < n; i++ {
+    if code := <-codes; code != http.StatusOK {
+      t.Errorf("expected status %d, got %d", http.StatusOK, code)
+    }
+  }
+  if server.Calls() != 1 {
+    t.Errorf("expected concurrent lookups to share one upstream call, got %d", server.Calls())
+  }
+}
+
+func TestCallerQuota(t *testing.T) {
+  server := newTestUpstream()
+  up := serveUpstream(t, server)
+  defer up.Close()
+  p := newTestProxy()
+  p.callers.lookup(CALLER_KEY).quota = 1
+  l, addr := serveTestProxy(t, p)
+  defer l.Close()
+  if code, _ := testGet(t, addr, "/v3/person/email/john.q.public@gmail.com", CALLER_KEY); code != http.StatusOK {
+    t.Errorf("expected status %d, got %d", http.StatusOK, code)
+  }
+  if code, _ := testGet(t, addr, "/v3/person/web/twitter/johnqpublic", CALLER_KEY); code != http.StatusForbidden {
+    t.Errorf("over quota: expected status %d, got %d", http.StatusForbidden, code)
+  }
+  if code, _ := testGet(t, addr, "/v3/person/email/john.q.public@gmail.com", CALLER_KEY); code != http.StatusOK {
+    t.Errorf("cached over quota: expected status %d, got %d", http.StatusOK, code)
+  }
+  if server.Calls() != 1 {
+    t.Errorf("expected no upstream call over quota, got %d", server.Calls())
+  }
+}
+
+func TestSearchingNotCached(t *testing.T) {
+  server := newTestUpstream()
+  server.Pending = 1
+  up := serveUpstream(t, server)
+  defer up.Close()
+  l, addr := serveTestProxy(t, newTestProxy())
+  defer l.Close()
+  if code, _ := testGet(t, addr, "/v3/person/email/john.q.public@gmail.com", CALLER_KEY); code != http.StatusAccepted {
+    t.Errorf("expected status %d, got %d", http.StatusAccepted, code)
+  }
+  if code, _ := testGet(t, addr, "/v3/person/email/john.q.public@gmail.com", CALLER_KEY); code != http.StatusOK {
+    t.Errorf("expected status %d once found, got %d", http.StatusOK, code)
+  }
+  if server.Calls() != 2 {
+    t.Errorf("expected the 202 not to be cached, got %d upstream calls", server.Calls())
+  }
+}
+
+func TestServerErrorNotCached(t *testing.T) {
+  calls := 0
+  var lock sync.Mutex
+  up := serveUpstream(t, http.HandlerFunc(func(conn *http.Conn, req *http.Request) {
+    lock.Lock()
+    calls++
+    lock.Unlock()
+    conn.WriteHeader(http.StatusInternalServerError)
+  }))
+  defer up.Close()
+  l, addr := serveTestProxy(t, newTestProxy())
+  defer l.Close()
+  for i := 0; i < 2; i++ {
+    if code, _ := testGet(t, addr, "/v3/person/email/john.q.public@gmail.com", CALLER_KEY); code != http.StatusInternalServerError {
+      t.Errorf("expected status %d, got %d", http.StatusInternalServerError, code)
+    }
+  }
+  lock.Lock()
+  defer lock.Unlock()
+  if calls != 2 {
+    t.Errorf("expected the 500 not to be cached, got %d upstream calls", calls)
+  }
+}
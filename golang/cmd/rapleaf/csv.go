@@ -0,0 +1,138 @@
+package main
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "bufio"
+  "bytes"
+  "io"
+  "os"
+  "strings"
+)
+
+// Minimal RFC 4180 reader and writer: comma separated, fields may be
+// quoted with '"' and quoted fields may contain commas, doubled quotes
+// and newlines.
+type csvReader struct {
+  r *bufio.Reader
+}
+
+func newCsvReader(r io.Reader) *csvReader {
+  return &csvReader{r:bufio.NewReader(r)}
+}
+
+func growStrings(a []string, s string) []string {
+  if len(a) == cap(a) {
+    b := make([]string, len(a), 2 * len(a) + 8)
+    copy(b, a)
+    a = b
+  }
+  a = a[0:len(a) + 1]
+  a[len(a) - 1] = s
+  return a
+}
+
+// ReadRecord returns os.EOF once the input is exhausted.
+func (p *csvReader) ReadRecord() ([]string, os.Error) {
+  record := make([]string, 0, 16)
+  field := bytes.NewBuffer(nil)
+  quoted := false
+  started := false
+  for {
+    c, err := p.r.ReadByte()
+    if err == os.EOF {
+      if !started && len(record) == 0 {
+        return nil, os.EOF
+      }
+      return growStrings(record, field.String()), nil
+    }
+    if err != nil {
+      return nil, err
+    }
+    started = true
+    if quoted {
+      if c != '"' {
+        field.WriteByte(c)
+        continue
+      }
+      next, err := p.r.ReadByte()
+      if err == nil && next == '"' {
+        field.WriteByte('"')
+        continue
+      }
+      if err == nil {
+        p.r.UnreadByte()
+      }
+      quoted = false
+      continue
+    }
+    switch c {
+    case '"':
+      quoted = true
+    case ',':
+      record = growStrings(record, field.String())
+      field.Reset()
+    case '\r':
+      // dropped; "\r\n" ends the record at the '\n'
+    case '\n':
+      return growStrings(record, field.String()), nil
+    default:
+      field.WriteByte(c)
+    }
+  }
+  return nil, os.EOF
+}
+
+type csvWriter struct {
+  w *bufio.Writer
+  written int64
+}
+
+func newCsvWriter(w io.Writer) *csvWriter {
+  return &csvWriter{w:bufio.NewWriter(w)}
+}
+
+func csvQuote(s string) string {
+  if strings.IndexAny(s, ",\"\r\n") < 0 {
+    return s
+  }
+  return "\"" + strings.Replace(s, "\"", "\"\"", -1) + "\""
+}
+
+func (p *csvWriter) WriteRecord(record []string) os.Error {
+  for i, field := range record {
+    if i > 0 {
+      if err := p.writeString(","); err != nil {
+        return err
+      }
+    }
+    if err := p.writeString(csvQuote(field)); err != nil {
+      return err
+    }
+  }
+  return p.writeString("\n")
+}
+
+func (p *csvWriter) writeString(s string) os.Error {
+  n, err := p.w.WriteString(s)
+  p.written += int64(n)
+  return err
+}
+
+func (p *csvWriter) Flush() os.Error {
+  return p.w.Flush()
+}
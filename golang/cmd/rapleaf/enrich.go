@@ -0,0 +1,270 @@
+package main
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "rapleaf"
+  "flag"
+  "fmt"
+  "http"
+  "io/ioutil"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+)
+
+var (
+  enrich_in = flag.String("in", "", "enrich: input CSV file")
+  enrich_out = flag.String("out", "", "enrich: output CSV file")
+  enrich_email_column = flag.String("email-column", "email", "enrich: name of the input column holding the email address")
+  enrich_checkpoint = flag.String("checkpoint", "", "enrich: checkpoint file (defaults to <out>.checkpoint)")
+  enrich_vcf = flag.String("vcf", "", "enrich: also write every person found to this vCard file")
+)
+
+// A checkpoint records how many input rows have been enriched and how many
+// bytes of the output file they account for.  On resume the output is cut
+// back to that length, so a row written just before a crash is neither lost
+// nor duplicated, and rows already paid for are not looked up again.  The
+// vCard file, if any, is cut back the same way.  Rows the API answered
+// with 202 are not written; they are kept in pending and looked up again,
+// and appended, by the next run.
+type checkpoint struct {
+  rows int
+  offset int64
+  vcf_offset int64
+  pending map[int]bool
+}
+
+func readCheckpoint(filename string) (*checkpoint, os.Error) {
+  buf, err := ioutil.ReadFile(filename)
+  if err != nil {
+    if e, ok := err.(*os.PathError); ok && e.Error == os.ENOENT {
+      return &checkpoint{pending:make(map[int]bool)}, nil
+    }
+    return nil, err
+  }
+  fields := strings.Fields(string(buf))
+  if len(fields) < 2 || len(fields) > 4 {
+    return nil, os.NewError("malformed checkpoint file " + filename)
+  }
+  rows, err := strconv.Atoi(fields[0])
+  if err != nil {
+    return nil, err
+  }
+  offset, err := strconv.Atoi64(fields[1])
+  if err != nil {
+    return nil, err
+  }
+  cp := &checkpoint{rows:rows, offset:offset, pending:make(map[int]bool)}
+  if len(fields) > 2 {
+    if cp.vcf_offset, err = strconv.Atoi64(fields[2]); err != nil {
+      return nil, err
+    }
+  }
+  if len(fields) > 3 && fields[3] != "-" {
+    for _, field := range strings.Split(fields[3], ",", -1) {
+      row, err := strconv.Atoi(field)
+      if err != nil {
+        return nil, err
+      }
+      cp.pending[row] = true
+    }
+  }
+  return cp, nil
+}
+
+func (p *checkpoint) write(filename string) os.Error {
+  tmp := filename + ".tmp"
+  pending := make([]int, len(p.pending))
+  i := 0
+  for row, _ := range p.pending {
+    pending[i] = row
+    i++
+  }
+  sort.SortInts(pending)
+  rows := make([]string, len(pending))
+  for i, row := range pending {
+    rows[i] = strconv.Itoa(row)
+  }
+  pending_str := strings.Join(rows, ",")
+  if len(pending_str) == 0 {
+    pending_str = "-"
+  }
+  text := strconv.Itoa(p.rows) + " " + strconv.Itoa64(p.offset) + " " + strconv.Itoa64(p.vcf_offset) + " " + pending_str + "\n"
+  if err := ioutil.WriteFile(tmp, []byte(text), 0644); err != nil {
+    return err
+  }
+  return os.Rename(tmp, filename)
+}
+
+// stopStatus reports whether a lookup status means the run cannot usefully
+// continue: the key is bad, the quota is spent or the API is unreachable.
+// The row is left unprocessed so the next run retries it.
+func stopStatus(code int) bool {
+  return code == http.StatusUnauthorized ||
+    code == http.StatusForbidden ||
+    code >= http.StatusInternalServerError
+}
+
+// openResumable opens filename for appending from offset, starting it
+// afresh when nothing has been written yet.
+func openResumable(filename string, offset int64, fresh bool) (*os.File, os.Error) {
+  if fresh {
+    return os.Open(filename, os.O_WRONLY | os.O_CREAT | os.O_TRUNC, 0644)
+  }
+  f, err := os.Open(filename, os.O_WRONLY | os.O_CREAT | os.O_APPEND, 0644)
+  if err != nil {
+    return nil, err
+  }
+  if err = f.Truncate(offset); err != nil {
+    f.Close()
+    return nil, err
+  }
+  return f, nil
+}
+
+func runEnrich() os.Error {
+  if len(*enrich_in) == 0 || len(*enrich_out) == 0 {
+    return os.NewError("--in and --out are required")
+  }
+  key := apiKey()
+  if len(key) == 0 {
+    return os.NewError("no API key; use --api-key or set RAPLEAF_API_KEY")
+  }
+  checkpoint_file := *enrich_checkpoint
+  if len(checkpoint_file) == 0 {
+    checkpoint_file = *enrich_out + ".checkpoint"
+  }
+  cp, err := readCheckpoint(checkpoint_file)
+  if err != nil {
+    return err
+  }
+  in, err := os.Open(*enrich_in, os.O_RDONLY, 0)
+  if err != nil {
+    return err
+  }
+  defer in.Close()
+  r := newCsvReader(in)
+  header, err := r.ReadRecord()
+  if err != nil {
+    return os.NewError("unable to read header from " + *enrich_in + ": " + err.String())
+  }
+  email_index := -1
+  for i, column := range header {
+    if column == *enrich_email_column {
+      email_index = i
+      break
+    }
+  }
+  if email_index < 0 {
+    return os.NewError("no column named " + strconv.Quote(*enrich_email_column) + " in " + *enrich_in)
+  }
+  out, err := openResumable(*enrich_out, cp.offset, cp.rows == 0)
+  if err != nil {
+    return err
+  }
+  defer out.Close()
+  var vcf *os.File
+  if len(*enrich_vcf) > 0 {
+    if vcf, err = openResumable(*enrich_vcf, cp.vcf_offset, cp.rows == 0); err != nil {
+      return err
+    }
+    defer vcf.Close()
+  }
+  w := newCsvWriter(out)
+  w.written = cp.offset
+  extra := flattenHeader()
+  if cp.rows == 0 {
+    v := make([]string, len(header) + len(extra))
+    copy(v, header)
+    copy(v[len(header):], extra)
+    if err = w.WriteRecord(v); err != nil {
+      return err
+    }
+    if err = w.Flush(); err != nil {
+      return err
+    }
+    cp.offset = w.written
+  }
+  for row := 0; ; row++ {
+    record, err := r.ReadRecord()
+    if err == os.EOF {
+      break
+    }
+    if err != nil {
+      return err
+    }
+    if row < cp.rows && !cp.pending[row] {
+      continue
+    }
+    var person *rapleaf.RapleafPerson
+    code := http.StatusBadRequest
+    if email_index < len(record) && len(record[email_index]) > 0 {
+      var text string
+      code, text = rapleaf.PersonXmlByEmail(key, record[email_index])
+      if stopStatus(code) {
+        return os.NewError(fmt.Sprintf("row %d: status %d: %s", row + 1, code, rapleaf.ERROR_CODES[code]))
+      }
+      if code == http.StatusAccepted {
+        cp.pending[row] = true
+        if row >= cp.rows {
+          cp.rows = row + 1
+        }
+        if err = cp.write(checkpoint_file); err != nil {
+          return err
+        }
+        continue
+      }
+      if code == http.StatusOK {
+        if person, _, err = rapleaf.DecodePerson(text, rapleaf.ParseOptions{}); err != nil {
+          // the row is written without enrichment rather than stopping
+          fmt.Fprintf(os.Stderr, "rapleaf enrich: row %d: %s\n", row + 1, err.String())
+          person = nil
+        }
+        if person != nil {
+          person.EmailAddress = record[email_index]
+        }
+      }
+    }
+    v := make([]string, len(header) + len(extra))
+    copy(v, record)
+    copy(v[len(header):], flattenPerson(code, person))
+    if err = w.WriteRecord(v); err != nil {
+      return err
+    }
+    if err = w.Flush(); err != nil {
+      return err
+    }
+    if vcf != nil && person != nil {
+      card := person.VCard()
+      if _, err = vcf.WriteString(card); err != nil {
+        return err
+      }
+      cp.vcf_offset += int64(len(card))
+    }
+    cp.pending[row] = false, false
+    if row >= cp.rows {
+      cp.rows = row + 1
+    }
+    cp.offset = w.written
+    if err = cp.write(checkpoint_file); err != nil {
+      return err
+    }
+  }
+  return nil
+}
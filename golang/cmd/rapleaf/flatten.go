@@ -0,0 +1,117 @@
+package main
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "rapleaf"
+  "strconv"
+  "strings"
+  "time"
+)
+
+const (
+  dateLayout = "2006-01-02"
+)
+
+var (
+  // one group of membership columns per primary site, so every row of an
+  // enriched file has the same shape regardless of what the API returned
+  flattenSites = rapleaf.PRIMARY_SITES
+  flattenPersonColumns = []string{
+    "rapleaf_status",
+    "rapleaf_id",
+    "name",
+    "gender",
+    "age",
+    "location",
+    "num_friends",
+    "earliest_known_activity",
+    "latest_known_activity",
+    "company",
+    "job_title",
+    "occupations",
+  }
+  flattenSiteColumns = []string{
+    "exists",
+    "profile_url",
+    "image_url",
+    "num_friends",
+    "num_followers",
+    "num_followed",
+  }
+)
+
+func flattenHeader() []string {
+  v := make([]string, len(flattenPersonColumns) + len(flattenSites) * len(flattenSiteColumns))
+  copy(v, flattenPersonColumns)
+  i := len(flattenPersonColumns)
+  for _, site := range flattenSites {
+    prefix := site.ShortName() + "_"
+    for _, column := range flattenSiteColumns {
+      v[i] = prefix + column
+      i++
+    }
+  }
+  return v
+}
+
+func formatDate(t *time.Time) string {
+  if t == nil {
+    return ""
+  }
+  return t.Format(dateLayout)
+}
+
+// flattenPerson returns one value per flattenHeader() column; p may be nil
+// when the lookup did not return a person.
+func flattenPerson(code int, p *rapleaf.RapleafPerson) []string {
+  v := make([]string, len(flattenHeader()))
+  v[0] = strconv.Itoa(code)
+  if p == nil {
+    return v
+  }
+  occupations := make([]string, len(p.Occupations))
+  for i, occupation := range p.Occupations {
+    occupations[i] = occupation.JobTitle + " at " + occupation.Company
+  }
+  v[1] = p.Id
+  v[2] = p.Name
+  v[3] = string(p.Gender)
+  v[4] = p.Age.String()
+  v[5] = p.Location
+  v[6] = p.NumFriends.String()
+  v[7] = formatDate(p.EarliestKnownActivity)
+  v[8] = formatDate(p.LatestKnownActivity)
+  if len(p.Occupations) > 0 {
+    v[9] = p.Occupations[0].Company
+    v[10] = p.Occupations[0].JobTitle
+  }
+  v[11] = strings.Join(occupations, "; ")
+  i := len(flattenPersonColumns)
+  for _, site := range flattenSites {
+    if membership := p.MembershipFor(string(site)); membership != nil {
+      v[i] = membership.Exists
+      v[i + 1] = membership.ProfileUrl
+      v[i + 2] = membership.ImageUrl
+      v[i + 3] = membership.NumFriends.String()
+      v[i + 4] = membership.NumFollowers.String()
+      v[i + 5] = membership.NumFollowed.String()
+    }
+    i += len(flattenSiteColumns)
+  }
+  return v
+}
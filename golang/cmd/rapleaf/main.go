@@ -0,0 +1,90 @@
+package main
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "rapleaf"
+  "flag"
+  "fmt"
+  "os"
+)
+
+var (
+  api_key = flag.String("api-key", "", "Rapleaf API key (defaults to $RAPLEAF_API_KEY)")
+  host = flag.String("host", "", "override the Rapleaf API host")
+  port = flag.String("port", "80", "override the Rapleaf API port")
+  api_version = flag.String("api-version", "v3", "Rapleaf API version to speak")
+)
+
+type command struct {
+  name string
+  usage string
+  run func() os.Error
+}
+
+var commands = []command{
+  command{"enrich", "enrich --in users.csv --email-column email --out enriched.csv [--vcf contacts.vcf]", runEnrich},
+  command{"refresh", "refresh --store people.log [--ttl seconds] [--budget n]", runRefresh},
+}
+
+func usage() {
+  fmt.Fprintf(os.Stderr, "usage: rapleaf <command> [flags]\n\ncommands:\n")
+  for _, c := range commands {
+    fmt.Fprintf(os.Stderr, "  %s\n", c.usage)
+  }
+  fmt.Fprintf(os.Stderr, "\nflags:\n")
+  flag.PrintDefaults()
+  os.Exit(2)
+}
+
+func apiKey() string {
+  if len(*api_key) > 0 {
+    return *api_key
+  }
+  return os.Getenv("RAPLEAF_API_KEY")
+}
+
+func main() {
+  flag.Usage = usage
+  if len(os.Args) < 2 {
+    usage()
+  }
+  name := os.Args[1]
+  // shift the command name off so the flag package sees only its flags
+  os.Args = os.Args[1:]
+  flag.Parse()
+  if len(*host) > 0 {
+    rapleaf.OverrideRapleafHostPort(*host, *port)
+  }
+  v, ok := rapleaf.ApiVersionNamed(*api_version)
+  if !ok {
+    fmt.Fprintf(os.Stderr, "rapleaf: unknown API version %q\n", *api_version)
+    os.Exit(2)
+  }
+  rapleaf.SetApiVersion(v)
+  for _, c := range commands {
+    if c.name == name {
+      if err := c.run(); err != nil {
+        fmt.Fprintf(os.Stderr, "rapleaf %s: %s\n", name, err.String())
+        os.Exit(1)
+      }
+      return
+    }
+  }
+  fmt.Fprintf(os.Stderr, "rapleaf: unknown command %q\n", name)
+  usage()
+}
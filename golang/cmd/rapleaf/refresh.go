@@ -0,0 +1,63 @@
+package main
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "rapleaf"
+  "flag"
+  "fmt"
+  "os"
+)
+
+var (
+  refresh_store = flag.String("store", "", "refresh: store file written by rapleaf.FileStore")
+  refresh_ttl = flag.Int64("ttl", 30 * 86400, "refresh: seconds after which a stored record is refreshed")
+  refresh_pending_ttl = flag.Int64("pending-ttl", 3600, "refresh: seconds after which a record last answered 202 is retried")
+  refresh_budget = flag.Int("budget", 0, "refresh: most lookups to make in this run (0 for no limit)")
+  refresh_json = flag.Bool("json", false, "refresh: print changes as JSON rather than text")
+)
+
+func runRefresh() os.Error {
+  if len(*refresh_store) == 0 {
+    return os.NewError("--store is required")
+  }
+  key := apiKey()
+  if len(key) == 0 {
+    return os.NewError("no API key; use --api-key or set RAPLEAF_API_KEY")
+  }
+  store, err := rapleaf.OpenFileStore(*refresh_store)
+  if err != nil {
+    return err
+  }
+  defer store.Close()
+  f := rapleaf.NewRefresher(store, key, *refresh_ttl)
+  f.StatusTTL = map[int]int64{202:*refresh_pending_ttl}
+  f.Budget = *refresh_budget
+  f.OnChange = func(r *rapleaf.StoredPerson, changes []*rapleaf.Change) {
+    if *refresh_json {
+      buf, err := rapleaf.DiffJSON(changes)
+      if err == nil {
+        fmt.Printf("{\"id\":%q,\"changes\":%s}\n", r.Person.Id, buf)
+      }
+      return
+    }
+    fmt.Printf("%s\n%s", r.Person.Id, rapleaf.DiffText(changes))
+  }
+  report, err := f.Run()
+  fmt.Fprintf(os.Stderr, "rapleaf refresh: %s\n", report.String())
+  return err
+}
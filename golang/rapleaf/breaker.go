@@ -0,0 +1,193 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "http"
+  "os"
+  "sync"
+  "time"
+)
+
+type CircuitState int
+
+const (
+  CIRCUIT_CLOSED CircuitState = iota
+  CIRCUIT_OPEN
+  CIRCUIT_HALF_OPEN
+)
+
+var (
+  // ErrCircuitOpen is the text of the 503 returned, without a request being
+  // made, by lookups while the circuit breaker is open.
+  ErrCircuitOpen = os.NewError("rapleaf: circuit breaker open")
+)
+
+func (s CircuitState) String() string {
+  switch s {
+  case CIRCUIT_CLOSED:
+    return "closed"
+  case CIRCUIT_OPEN:
+    return "open"
+  case CIRCUIT_HALF_OPEN:
+    return "half-open"
+  }
+  return "unknown"
+}
+
+// A CircuitBreaker stops lookups from waiting on an API that is down.  It
+// opens once failures make up FailureRate of at least MinRequests lookups
+// within Window, fails lookups fast for Cooldown, then lets HalfOpenProbes
+// lookups through: one success closes it again and one failure reopens it.
+// A failure is a 5xx status or a request that got no response at all.
+// Durations are in nanoseconds.
+type CircuitBreaker struct {
+  FailureRate float64
+  MinRequests int
+  Window int64
+  Cooldown int64
+  HalfOpenProbes int
+  // OnStateChange, if set, is called after every change of state.
+  OnStateChange func(from, to CircuitState)
+  lock sync.Mutex
+  state CircuitState
+  windowStart int64
+  requests int
+  failures int
+  openedAt int64
+  probes int
+}
+
+func NewCircuitBreaker(failure_rate float64, window, cooldown int64) *CircuitBreaker {
+  return &CircuitBreaker{
+    FailureRate:failure_rate,
+    MinRequests:10,
+    Window:window,
+    Cooldown:cooldown,
+    HalfOpenProbes:1,
+  }
+}
+
+var (
+  breaker *CircuitBreaker
+  breakerLock sync.RWMutex
+)
+
+// SetCircuitBreaker installs b in front of all lookups; nil removes it.
+func SetCircuitBreaker(b *CircuitBreaker) {
+  breakerLock.Lock()
+  breaker = b
+  breakerLock.Unlock()
+}
+
+func currentCircuitBreaker() *CircuitBreaker {
+  breakerLock.RLock()
+  defer breakerLock.RUnlock()
+  return breaker
+}
+
+func (b *CircuitBreaker) State() CircuitState {
+  b.lock.Lock()
+  defer b.lock.Unlock()
+  return b.state
+}
+
+// setState must be called with b.lock held; it returns the callback to run
+// once the lock is released.
+func (b *CircuitBreaker) setState(to CircuitState, now int64) func() {
+  from := b.state
+  if from == to {
+    return nil
+  }
+  b.state = to
+  b.windowStart = now
+  b.requests = 0
+  b.failures = 0
+  b.probes = 0
+  if to == CIRCUIT_OPEN {
+    b.openedAt = now
+  }
+  if b.OnStateChange == nil {
+    return nil
+  }
+  callback := b.OnStateChange
+  return func() { callback(from, to) }
+}
+
+// Allow reports whether a lookup may go ahead.
+func (b *CircuitBreaker) Allow() bool {
+  now := time.Nanoseconds()
+  b.lock.Lock()
+  var notify func()
+  if b.state == CIRCUIT_OPEN && now - b.openedAt >= b.Cooldown {
+    notify = b.setState(CIRCUIT_HALF_OPEN, now)
+  }
+  allowed := true
+  switch b.state {
+  case CIRCUIT_OPEN:
+    allowed = false
+  case CIRCUIT_HALF_OPEN:
+    if b.probes >= b.HalfOpenProbes {
+      allowed = false
+    } else {
+      b.probes++
+    }
+  }
+  b.lock.Unlock()
+  if notify != nil {
+    notify()
+  }
+  return allowed
+}
+
+// Record reports the outcome of a lookup that Allow let through.
+func (b *CircuitBreaker) Record(success bool) {
+  now := time.Nanoseconds()
+  b.lock.Lock()
+  var notify func()
+  switch b.state {
+  case CIRCUIT_HALF_OPEN:
+    if success {
+      notify = b.setState(CIRCUIT_CLOSED, now)
+    } else {
+      notify = b.setState(CIRCUIT_OPEN, now)
+    }
+  case CIRCUIT_CLOSED:
+    if now - b.windowStart >= b.Window {
+      b.windowStart = now
+      b.requests = 0
+      b.failures = 0
+    }
+    b.requests++
+    if !success {
+      b.failures++
+    }
+    if b.requests >= b.MinRequests && float64(b.failures) >= b.FailureRate * float64(b.requests) {
+      notify = b.setState(CIRCUIT_OPEN, now)
+    }
+  }
+  b.lock.Unlock()
+  if notify != nil {
+    notify()
+  }
+}
+
+// failedStatus reports whether a status from fetch counts against the
+// breaker; 204 is what fetch reports when no response came back.
+func failedStatus(code int) bool {
+  return code >= http.StatusInternalServerError || code == http.StatusNoContent
+}
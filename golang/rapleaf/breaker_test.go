@@ -0,0 +1,91 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "http"
+  "testing"
+  "time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+  b := NewCircuitBreaker(0.5, 60e9, 1e6)
+  b.MinRequests = 2
+  changes := make([]string, 0, 3)
+  b.OnStateChange = func(from, to CircuitState) {
+    changes = changes[0:len(changes) + 1]
+    changes[len(changes) - 1] = from.String() + "->" + to.String()
+  }
+  b.Record(true)
+  b.Record(false)
+  if b.State() != CIRCUIT_OPEN {
+    t.Errorf("Expected breaker to open at 1 failure in 2 but found %s", b.State())
+  }
+  if b.Allow() {
+    t.Error("Expected open breaker to refuse lookups")
+  }
+  time.Sleep(2e6)
+  if !b.Allow() {
+    t.Error("Expected breaker to let a probe through after the cooldown")
+  }
+  if b.State() != CIRCUIT_HALF_OPEN {
+    t.Errorf("Expected half-open breaker but found %s", b.State())
+  }
+  if b.Allow() {
+    t.Error("Expected half-open breaker to allow only one probe")
+  }
+  b.Record(true)
+  if b.State() != CIRCUIT_CLOSED {
+    t.Errorf("Expected breaker to close after a good probe but found %s", b.State())
+  }
+  expected := []string{"closed->open", "open->half-open", "half-open->closed"}
+  if len(changes) != len(expected) {
+    t.Errorf("Expected state changes %v but found %v", expected, changes)
+    return
+  }
+  for i, change := range expected {
+    if changes[i] != change {
+      t.Errorf("Expected state change %s but found %s", change, changes[i])
+    }
+  }
+}
+
+func TestCircuitBreakerFailsFast(t *testing.T) {
+  l, err := serveTestFiles(t)
+  if err != nil {
+    return
+  }
+  // nothing listens on the port once the server is closed
+  closeServerTestFiles(l)
+  b := NewCircuitBreaker(0.5, 60e9, 60e9)
+  b.MinRequests = 2
+  SetCircuitBreaker(b)
+  code1, _ := PersonXmlByEmail(API_KEY, "john.q.public@gmail.com")
+  code2, _ := PersonXmlByEmail(API_KEY, "john.q.public@gmail.com")
+  _, r := PersonByEmailWithResponse(API_KEY, "john.q.public@gmail.com")
+  SetCircuitBreaker(nil)
+  if code1 != http.StatusServiceUnavailable || code2 != http.StatusServiceUnavailable {
+    t.Errorf("Expected status code 503 from a closed port but received %d and %d", code1, code2)
+  }
+  if r.Status != http.StatusServiceUnavailable || r.Err != ErrCircuitOpen {
+    t.Errorf("Expected %s but received %d: %v", ErrCircuitOpen.String(), r.Status, r.Err)
+  }
+  if b.State() != CIRCUIT_OPEN {
+    t.Errorf("Expected open breaker but found %s", b.State())
+  }
+}
@@ -0,0 +1,86 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "os"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+const (
+  DEFAULT_MAX_BODY_SIZE = 1 << 20
+  // leading bytes of a rejected body kept for diagnosis
+  contentErrorLeading = 64
+)
+
+var (
+  ErrUnexpectedContent = os.NewError("rapleaf: unexpected response content")
+  ErrBodyTooLarge = os.NewError("rapleaf: response body too large")
+
+  maxBodySize int64 = DEFAULT_MAX_BODY_SIZE
+  maxBodySizeLock sync.RWMutex
+)
+
+// SetMaxBodySize bounds the bytes read from any response; zero or less
+// removes the bound.
+func SetMaxBodySize(n int64) {
+  maxBodySizeLock.Lock()
+  maxBodySize = n
+  maxBodySizeLock.Unlock()
+}
+
+func currentMaxBodySize() int64 {
+  maxBodySizeLock.RLock()
+  defer maxBodySizeLock.RUnlock()
+  return maxBodySize
+}
+
+// A ContentError describes a response that was refused before parsing: a
+// 200 with no content type, or one the API does not send for the endpoint,
+// such as the HTML of a captive portal, or a body over the size limit.
+// Reason is ErrUnexpectedContent or ErrBodyTooLarge.  The lookup reports it
+// as a synthesized 502 with the error in Response.Err.
+type ContentError struct {
+  Reason os.Error
+  Status int
+  ContentType string
+  Leading string
+}
+
+func (e *ContentError) String() string {
+  return e.Reason.String() + ": status " + strconv.Itoa(e.Status) +
+    ", content type " + strconv.Quote(e.ContentType) +
+    ", starting " + strconv.Quote(e.Leading)
+}
+
+func newContentError(reason os.Error, status int, content_type string, body []byte) *ContentError {
+  if len(body) > contentErrorLeading {
+    body = body[0:contentErrorLeading]
+  }
+  return &ContentError{Reason:reason, Status:status, ContentType:content_type, Leading:string(body)}
+}
+
+// mediaType returns the lowercased type and subtype of a Content-Type
+// header, without parameters.
+func mediaType(content_type string) string {
+  if i := strings.Index(content_type, ";"); i >= 0 {
+    content_type = content_type[0:i]
+  }
+  return strings.ToLower(strings.TrimSpace(content_type))
+}
@@ -0,0 +1,103 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "http"
+  "strings"
+  "testing"
+)
+
+func TestUnexpectedContent(t *testing.T) {
+  portal := "<html><head><title>Sign in to the hotel wifi</title></head><body>" + strings.Repeat("x", 200) + "</body></html>"
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "text/html; charset=UTF-8", portal)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  u, r := PersonByEmailWithResponse(API_KEY, "john.q.public@gmail.com")
+  closeServerTestFiles(l)
+  if u != nil || r.Status != 502 || !r.Synthesized {
+    t.Errorf("Expected a synthesized 502 for an HTML page but found %v", r)
+  }
+  e, ok := r.Err.(*ContentError)
+  if !ok || e.Reason != ErrUnexpectedContent || e.Status != 200 || e.ContentType != "text/html; charset=UTF-8" {
+    t.Errorf("Expected an unexpected content error but found %v", r.Err)
+    return
+  }
+  if e.Leading != portal[0:64] || strings.Index(r.Body, "Sign in to the hotel wifi") < 0 {
+    t.Errorf("Expected the leading bytes of the page in the error but found %s", r.Body)
+  }
+}
+
+func TestMissingContentType(t *testing.T) {
+  l, err := serveTestHandler(t, http.HandlerFunc(func(conn *http.Conn, req *http.Request) {
+    // the server sends text/html unless told otherwise
+    conn.SetHeader("Content-Type", "")
+    conn.WriteHeader(http.StatusOK)
+    conn.Write([]byte(USER_WITH_PROFILE_XML))
+  }))
+  if err != nil {
+    return
+  }
+  u, r := PersonByEmailWithResponse(API_KEY, "john.q.public@gmail.com")
+  closeServerTestFiles(l)
+  if u != nil || r.Status != 502 || !r.Synthesized {
+    t.Errorf("Expected a synthesized 502 for a body without a content type but found %v", r)
+  }
+  if e, ok := r.Err.(*ContentError); !ok || e.Reason != ErrUnexpectedContent || e.Status != 200 || len(e.ContentType) != 0 {
+    t.Errorf("Expected an unexpected content error but found %v", r.Err)
+  }
+}
+
+func TestAcceptedWithoutContentType(t *testing.T) {
+  l, err := serveTestHandler(t, http.HandlerFunc(func(conn *http.Conn, req *http.Request) {
+    conn.SetHeader("Content-Type", "")
+    conn.WriteHeader(http.StatusAccepted)
+    conn.Write([]byte(ERROR_CODES[http.StatusAccepted]))
+  }))
+  if err != nil {
+    return
+  }
+  _, r := PersonByEmailWithResponse(API_KEY, "john.q.public@gmail.com")
+  closeServerTestFiles(l)
+  if r.Status != http.StatusAccepted || r.Synthesized || r.Err != nil {
+    t.Errorf("Expected the 202 to pass through but found %v", r)
+  }
+}
+
+func TestMaxBodySize(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  SetMaxBodySize(100)
+  _, r := PersonByEmailWithResponse(API_KEY, "john.q.public@gmail.com")
+  SetMaxBodySize(DEFAULT_MAX_BODY_SIZE)
+  u, ok_response := PersonByEmailWithResponse(API_KEY, "john.q.public@gmail.com")
+  closeServerTestFiles(l)
+  if e, ok := r.Err.(*ContentError); !ok || e.Reason != ErrBodyTooLarge || r.Status != 502 {
+    t.Errorf("Expected a body too large error but found %v", r)
+  }
+  if u == nil || ok_response.Err != nil {
+    t.Errorf("Expected the lookup to succeed under the default limit but found %v", ok_response)
+  }
+}
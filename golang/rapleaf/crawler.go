@@ -0,0 +1,183 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "bytes"
+  "fmt"
+  "json"
+  "os"
+  "strconv"
+  "time"
+)
+
+// DEFAULT_CRAWL_INTERVAL spaces the lookups of a new Crawler, in
+// nanoseconds, so that a crawl does not hammer the API.
+const DEFAULT_CRAWL_INTERVAL = 100e6
+
+type IdentityNode struct {
+  Id string "id"
+  Depth int "depth"
+  // Person is nil when the Rapleaf id could not be resolved
+  Person *RapleafPerson "person"
+}
+
+type IdentityEdge struct {
+  From string "from"
+  To string "to"
+}
+
+// An IdentityGraph is the result of a crawl: nodes are persons keyed by
+// Rapleaf id and an edge leads from each node queried to every id the graph
+// endpoint returned for it.
+type IdentityGraph struct {
+  Root string "root"
+  Nodes []*IdentityNode "nodes"
+  Edges []*IdentityEdge "edges"
+  byId map[string]*IdentityNode
+  edges map[string]bool
+}
+
+// A Crawler expands the graph endpoint breadth first from one email.
+type Crawler struct {
+  ApiKey string
+  Tag CallerTag
+  // MaxDepth is the number of hops from the starting person to follow
+  MaxDepth int
+  // MaxNodes bounds the number of persons resolved, and so the number of
+  // lookups made; every node costs one graph and one person lookup.
+  MaxNodes int
+  // Interval is the least time, in nanoseconds, between two lookups;
+  // NewCrawler sets DEFAULT_CRAWL_INTERVAL and zero turns the limit off
+  Interval int64
+  last int64
+}
+
+func NewCrawler(api_key string, max_depth, max_nodes int) *Crawler {
+  return &Crawler{ApiKey:api_key, MaxDepth:max_depth, MaxNodes:max_nodes, Interval:DEFAULT_CRAWL_INTERVAL}
+}
+
+func (c *Crawler) wait() {
+  if c.Interval > 0 {
+    if d := c.last + c.Interval - time.Nanoseconds(); d > 0 {
+      time.Sleep(d)
+    }
+  }
+  c.last = time.Nanoseconds()
+}
+
+func newIdentityGraph() *IdentityGraph {
+  return &IdentityGraph{
+    Nodes:make([]*IdentityNode, 0, 16),
+    Edges:make([]*IdentityEdge, 0, 16),
+    byId:make(map[string]*IdentityNode),
+    edges:make(map[string]bool),
+  }
+}
+
+func (g *IdentityGraph) Node(id string) *IdentityNode {
+  return g.byId[id]
+}
+
+func (g *IdentityGraph) addNode(n *IdentityNode) {
+  if len(g.Nodes) == cap(g.Nodes) {
+    nodes := make([]*IdentityNode, len(g.Nodes), 2 * len(g.Nodes) + 16)
+    copy(nodes, g.Nodes)
+    g.Nodes = nodes
+  }
+  g.Nodes = g.Nodes[0:len(g.Nodes) + 1]
+  g.Nodes[len(g.Nodes) - 1] = n
+  g.byId[n.Id] = n
+}
+
+func (g *IdentityGraph) addEdge(from, to string) {
+  key := from + "\n" + to
+  if from == to || g.edges[key] {
+    return
+  }
+  g.edges[key] = true
+  if len(g.Edges) == cap(g.Edges) {
+    edges := make([]*IdentityEdge, len(g.Edges), 2 * len(g.Edges) + 16)
+    copy(edges, g.Edges)
+    g.Edges = edges
+  }
+  g.Edges = g.Edges[0:len(g.Edges) + 1]
+  g.Edges[len(g.Edges) - 1] = &IdentityEdge{From:from, To:to}
+}
+
+// Crawl resolves email, then follows the graph endpoint from it.  The root
+// node is keyed by the person's Rapleaf id, or by email if the API does not
+// know it.
+func (c *Crawler) Crawl(email string) *IdentityGraph {
+  g := newIdentityGraph()
+  if c.MaxNodes <= 0 {
+    return g
+  }
+  c.wait()
+  root := &IdentityNode{Id:email, Person:c.Tag.PersonByEmail(c.ApiKey, email)}
+  if root.Person != nil && len(root.Person.Id) > 0 {
+    root.Id = root.Person.Id
+  }
+  g.Root = root.Id
+  g.addNode(root)
+  // the root is queried by email since that is all the caller knows
+  queries := map[string]string{root.Id: email}
+  for i := 0; i < len(g.Nodes); i++ {
+    n := g.Nodes[i]
+    if n.Depth >= c.MaxDepth {
+      continue
+    }
+    query, ok := queries[n.Id]
+    if !ok {
+      query = n.Id
+    }
+    c.wait()
+    for _, id := range c.Tag.RapleafIdsByGraph(c.ApiKey, query) {
+      if _, seen := g.byId[id]; !seen {
+        if len(g.Nodes) >= c.MaxNodes {
+          continue
+        }
+        c.wait()
+        g.addNode(&IdentityNode{Id:id, Depth:n.Depth + 1, Person:c.Tag.PersonByRapleafId(c.ApiKey, id)})
+      }
+      g.addEdge(n.Id, id)
+    }
+  }
+  return g
+}
+
+// DOT renders the graph for Graphviz, labelling each node with the
+// person's name when known.
+func (g *IdentityGraph) DOT() string {
+  b := bytes.NewBufferString("digraph identity {\n")
+  for _, n := range g.Nodes {
+    label := n.Id
+    if n.Person != nil && len(n.Person.Name) > 0 {
+      label = n.Person.Name + "\n" + n.Id
+    }
+    fmt.Fprintf(b, "  %s [label=%s];\n", strconv.Quote(n.Id), strconv.Quote(label))
+  }
+  for _, e := range g.Edges {
+    fmt.Fprintf(b, "  %s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+  }
+  b.WriteString("}\n")
+  return b.String()
+}
+
+func (g *IdentityGraph) JSON() ([]byte, os.Error) {
+  return json.Marshal(g)
+}
@@ -0,0 +1,111 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "strings"
+  "testing"
+  "time"
+)
+
+func crawlTestServer() *MockServer {
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  server.AddFixture("/v3/person/web/rapleaf/b34282025d7e2c5db6786a8daaab48c7", "application/xml;charset=UTF-8", USER_EMPTY_XML)
+  server.AddFixture("/v2/graph/john.q.public@gmail.com?n=1", "text/plain;charset=UTF-8", "97fc425100000000\nb34282025d7e2c5db6786a8daaab48c7\n")
+  server.AddFixture("/v2/graph/b34282025d7e2c5db6786a8daaab48c7?n=1", "text/plain;charset=UTF-8", "97fc425100000000\n0000000000000000\n")
+  return server
+}
+
+func TestCrawl(t *testing.T) {
+  l, err := serveTestHandler(t, crawlTestServer())
+  if err != nil {
+    return
+  }
+  g := NewCrawler(API_KEY, 2, 10).Crawl("john.q.public@gmail.com")
+  closeServerTestFiles(l)
+  if g.Root != "97fc425100000000" {
+    t.Errorf("Expected root 97fc425100000000 but found %s", g.Root)
+  }
+  if len(g.Nodes) != 3 {
+    t.Errorf("Expected 3 nodes but found %d", len(g.Nodes))
+    return
+  }
+  testSamePerson(t, USER_EMPTY_PERSON, g.Node("b34282025d7e2c5db6786a8daaab48c7").Person)
+  unknown := g.Node("0000000000000000")
+  if unknown == nil || unknown.Person != nil || unknown.Depth != 2 {
+    t.Errorf("Expected unresolved node at depth 2 but found %v", unknown)
+  }
+  expected := []string{
+    "97fc425100000000 b34282025d7e2c5db6786a8daaab48c7",
+    "b34282025d7e2c5db6786a8daaab48c7 97fc425100000000",
+    "b34282025d7e2c5db6786a8daaab48c7 0000000000000000",
+  }
+  if len(g.Edges) != len(expected) {
+    t.Errorf("Expected %d edges but found %d", len(expected), len(g.Edges))
+    return
+  }
+  for i, e := range g.Edges {
+    if e.From + " " + e.To != expected[i] {
+      t.Errorf("Expected edge %s but found %s %s", expected[i], e.From, e.To)
+    }
+  }
+  dot := g.DOT()
+  if strings.Index(dot, "\"97fc425100000000\" [label=\"John Q Public\\n97fc425100000000\"];\n") < 0 ||
+      strings.Index(dot, "\"b34282025d7e2c5db6786a8daaab48c7\" -> \"0000000000000000\";\n") < 0 {
+    t.Errorf("Expected labelled nodes and edges in DOT output but found:\n%s", dot)
+  }
+  if _, err := g.JSON(); err != nil {
+    t.Error("Unable to render identity graph as JSON: ", err.String())
+  }
+}
+
+func TestCrawlNodeBudget(t *testing.T) {
+  server := crawlTestServer()
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  g := NewCrawler(API_KEY, 5, 2).Crawl("john.q.public@gmail.com")
+  closeServerTestFiles(l)
+  if len(g.Nodes) != 2 || len(g.Edges) != 2 {
+    t.Errorf("Expected 2 nodes and 2 edges within budget but found %d and %d", len(g.Nodes), len(g.Edges))
+  }
+  if g.Node("0000000000000000") != nil {
+    t.Error("Expected node over budget to be skipped")
+  }
+}
+
+func TestCrawlRateLimit(t *testing.T) {
+  server := crawlTestServer()
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  c := NewCrawler(API_KEY, 1, 10)
+  if c.Interval != DEFAULT_CRAWL_INTERVAL || c.Interval <= 0 {
+    t.Errorf("Expected a new crawler to be rate limited by default but found interval %d", c.Interval)
+  }
+  start := time.Nanoseconds()
+  c.Crawl("john.q.public@gmail.com")
+  elapsed := time.Nanoseconds() - start
+  closeServerTestFiles(l)
+  if calls := int64(server.Calls()); elapsed < (calls - 1) * DEFAULT_CRAWL_INTERVAL {
+    t.Errorf("Expected %d lookups to take at least %dns but took %dns", calls, (calls - 1) * DEFAULT_CRAWL_INTERVAL, elapsed)
+  }
+}
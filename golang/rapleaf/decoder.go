@@ -0,0 +1,179 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "bufio"
+  "io"
+  "os"
+  "strconv"
+  "xml"
+)
+
+// countingReader hands the XML parser one byte at a time, keeping track of
+// where in the input it is, and lets the Decoder push bytes back when it
+// resynchronizes after a bad record.
+type countingReader struct {
+  r *bufio.Reader
+  pending []byte
+  line int
+  offset int64
+}
+
+func (c *countingReader) ReadByte() (byte, os.Error) {
+  var b byte
+  if len(c.pending) > 0 {
+    b = c.pending[0]
+    c.pending = c.pending[1:]
+  } else {
+    var err os.Error
+    if b, err = c.r.ReadByte(); err != nil {
+      return 0, err
+    }
+  }
+  c.offset++
+  if b == '\n' {
+    c.line++
+  }
+  return b, nil
+}
+
+func (c *countingReader) Read(p []byte) (int, os.Error) {
+  if len(p) == 0 {
+    return 0, nil
+  }
+  b, err := c.ReadByte()
+  if err != nil {
+    return 0, err
+  }
+  p[0] = b
+  return 1, nil
+}
+
+// unread must only be given bytes just returned by ReadByte.
+func (c *countingReader) unread(b []byte) {
+  pending := make([]byte, len(b) + len(c.pending))
+  copy(pending, b)
+  copy(pending[len(b):], c.pending)
+  c.pending = pending
+  c.offset -= int64(len(b))
+  for _, x := range b {
+    if x == '\n' {
+      c.line--
+    }
+  }
+}
+
+// A DecodeError locates a malformed record in the input.  Line counts from
+// 1 and Offset is the number of bytes read before the error was found.
+type DecodeError struct {
+  Line int
+  Offset int64
+  Err os.Error
+}
+
+func (e *DecodeError) String() string {
+  return "rapleaf: line " + strconv.Itoa(e.Line) + ", offset " + strconv.Itoa64(e.Offset) + ": " + e.Err.String()
+}
+
+// A Decoder reads a stream of <person> documents, such as an archive of
+// API responses written one after another, holding only one record in
+// memory at a time.
+type Decoder struct {
+  Options ParseOptions
+  // Warnings holds the coercion failures of the last record decoded
+  Warnings []*ParseWarning
+  r *countingReader
+  p *xml.Parser
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+  c := &countingReader{r:bufio.NewReader(r), line:1}
+  return &Decoder{r:c, p:xml.NewParser(c)}
+}
+
+// Decode returns the next person in the stream, or os.EOF once there are
+// no more.  A malformed record, or in strict mode one with invalid values,
+// is reported as a *DecodeError; call Skip to carry on with the record
+// after it.
+func (d *Decoder) Decode() (*RapleafPerson, os.Error) {
+  for {
+    t, err := d.p.Token()
+    if err == os.EOF {
+      return nil, os.EOF
+    }
+    if err != nil {
+      return nil, d.error(err)
+    }
+    start, ok := t.(xml.StartElement)
+    if !ok || start.Name.Local != "person" {
+      continue
+    }
+    p := &rapleafPerson{}
+    if err = d.p.Unmarshal(p, &start); err != nil {
+      if err == os.EOF {
+        err = io.ErrUnexpectedEOF
+      }
+      return nil, d.error(err)
+    }
+    u, warnings, err := p.convert(d.Options)
+    d.Warnings = warnings
+    if err != nil {
+      return nil, d.error(err)
+    }
+    return u, nil
+  }
+  return nil, os.EOF
+}
+
+func (d *Decoder) error(err os.Error) *DecodeError {
+  return &DecodeError{Line:d.r.line, Offset:d.r.offset, Err:err}
+}
+
+// Skip discards input up to the start of the next <person> element and
+// starts parsing afresh from there.  It returns os.EOF if there is none.
+func (d *Decoder) Skip() os.Error {
+  const tag = "<person"
+  matched := 0
+  for {
+    b, err := d.r.ReadByte()
+    if err != nil {
+      return err
+    }
+    if matched < len(tag) {
+      if b == tag[matched] {
+        matched++
+      } else if b == tag[0] {
+        matched = 1
+      } else {
+        matched = 0
+      }
+      continue
+    }
+    if b == '>' || b == '/' || b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+      // hand the whole start tag back to a new parser
+      d.r.unread([]byte(tag + string(b)))
+      break
+    }
+    matched = 0
+    if b == tag[0] {
+      matched = 1
+    }
+  }
+  d.p = xml.NewParser(d.r)
+  return nil
+}
@@ -0,0 +1,67 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "os"
+  "strings"
+  "testing"
+)
+
+func TestDecoder(t *testing.T) {
+  bad := "<person id=\"bad\"><basics><name>x</nam></basics></person>"
+  d := NewDecoder(strings.NewReader(USER_EMPTY_XML + "\n" + bad + "\n" + USER_WITH_PROFILE_XML + "\n"))
+  u, err := d.Decode()
+  if err != nil {
+    t.Error("Unable to decode first record: ", err.String())
+    return
+  }
+  testSamePerson(t, USER_EMPTY_PERSON, u)
+  _, err = d.Decode()
+  e, ok := err.(*DecodeError)
+  if !ok {
+    t.Errorf("Expected a DecodeError for the malformed record but found %v", err)
+    return
+  }
+  if e.Line != 2 || e.Offset <= int64(len(USER_EMPTY_XML)) {
+    t.Errorf("Expected the error on line 2 past offset %d but found %s", len(USER_EMPTY_XML), e.String())
+  }
+  if err = d.Skip(); err != nil {
+    t.Error("Unable to skip the malformed record: ", err.String())
+    return
+  }
+  u, err = d.Decode()
+  if err != nil {
+    t.Error("Unable to decode the record after the malformed one: ", err.String())
+    return
+  }
+  testSamePerson(t, USER_WITH_PROFILE_PERSON, u)
+  if _, err = d.Decode(); err != os.EOF {
+    t.Errorf("Expected os.EOF at the end of the stream but found %v", err)
+  }
+}
+
+func TestDecoderTruncated(t *testing.T) {
+  d := NewDecoder(strings.NewReader(USER_WITH_PROFILE_XML[0:200]))
+  if _, err := d.Decode(); err == nil || err == os.EOF {
+    t.Errorf("Expected an error for a truncated record but found %v", err)
+  }
+  if err := d.Skip(); err != os.EOF {
+    t.Errorf("Expected os.EOF skipping past a truncated record but found %v", err)
+  }
+}
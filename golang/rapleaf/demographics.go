@@ -0,0 +1,122 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "os"
+  "strconv"
+  "strings"
+)
+
+type Gender string
+
+const (
+  GENDER_UNKNOWN = Gender("")
+  GENDER_MALE = Gender("male")
+  GENDER_FEMALE = Gender("female")
+
+  AGE_UNKNOWN = "unknown"
+)
+
+var ErrUnknownGender = os.NewError("rapleaf: unrecognized gender")
+
+// ParseGender accepts the API's "Male" and "Female" in any case, and "m"
+// or "f".  Anything else comes back trimmed and lowercased, like the known
+// values, with ErrUnknownGender; it is not Known and prints as unknown, but
+// the value the API sent is not lost.
+func ParseGender(s string) (Gender, os.Error) {
+  s = strings.ToLower(strings.TrimSpace(s))
+  switch s {
+  case "":
+    return GENDER_UNKNOWN, nil
+  case "male", "m":
+    return GENDER_MALE, nil
+  case "female", "f":
+    return GENDER_FEMALE, nil
+  }
+  return Gender(s), ErrUnknownGender
+}
+
+func (g Gender) Known() bool {
+  return g == GENDER_MALE || g == GENDER_FEMALE
+}
+
+func (g Gender) String() string {
+  if !g.Known() {
+    return "unknown"
+  }
+  return string(g)
+}
+
+// AgeBuckets holds the lowest age of each bucket in increasing order;
+// {18, 25, 35} gives "under 18", "18-24", "25-34" and "35+".
+type AgeBuckets []int
+
+var DefaultAgeBuckets = AgeBuckets{18, 25, 35, 45, 55, 65}
+
+// Bucket names the bucket age falls in, or AGE_UNKNOWN.
+func (b AgeBuckets) Bucket(age OptionalInt) string {
+  if !age.Valid() {
+    return AGE_UNKNOWN
+  }
+  n := age.Int()
+  if len(b) == 0 {
+    return strconv.Itoa(n)
+  }
+  if n < b[0] {
+    return "under " + strconv.Itoa(b[0])
+  }
+  for i := 1; i < len(b); i++ {
+    if n < b[i] {
+      return strconv.Itoa(b[i - 1]) + "-" + strconv.Itoa(b[i] - 1)
+    }
+  }
+  return strconv.Itoa(b[len(b) - 1]) + "+"
+}
+
+// Demographics is the view of a person that reports group by.  Gender is
+// GENDER_UNKNOWN unless Known.  Country and Region are the codes from
+// ParsedLocation.
+type Demographics struct {
+  Gender Gender
+  AgeBucket string
+  Country string
+  Region string
+}
+
+// Key joins the fields into one string, for use as a map key.
+func (d *Demographics) Key() string {
+  return d.Gender.String() + "/" + d.AgeBucket + "/" + d.Country + "/" + d.Region
+}
+
+func (p *RapleafPerson) Demographics() *Demographics {
+  return p.DemographicsWith(DefaultAgeBuckets)
+}
+
+func (p *RapleafPerson) DemographicsWith(buckets AgeBuckets) *Demographics {
+  location := p.ParsedLocation()
+  gender := p.Gender
+  if !gender.Known() {
+    gender = GENDER_UNKNOWN
+  }
+  return &Demographics{
+    Gender:gender,
+    AgeBucket:buckets.Bucket(p.Age),
+    Country:location.CountryCode,
+    Region:location.RegionCode,
+  }
+}
@@ -0,0 +1,83 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "strings"
+  "testing"
+)
+
+func TestParseGender(t *testing.T) {
+  for raw, expected := range map[string]Gender{"Male":GENDER_MALE, " female ":GENDER_FEMALE, "F":GENDER_FEMALE, "":GENDER_UNKNOWN} {
+    if g, err := ParseGender(raw); g != expected || err != nil {
+      t.Errorf("Expected %q to parse as %s but found %s (%v)", raw, expected.String(), g.String(), err)
+    }
+  }
+  if g, err := ParseGender(" Robot "); g != Gender("robot") || g.Known() || g.String() != "unknown" || err != ErrUnknownGender {
+    t.Errorf("Expected an unrecognized gender to keep its value but be unknown, with an error, but found %q (%v)", string(g), err)
+  }
+  if GENDER_UNKNOWN.String() != "unknown" {
+    t.Errorf("Expected unknown gender to print as unknown but found %q", GENDER_UNKNOWN.String())
+  }
+}
+
+func TestAgeBuckets(t *testing.T) {
+  buckets := AgeBuckets{18, 25, 35}
+  for age, expected := range map[int]string{0:"under 18", 17:"under 18", 18:"18-24", 24:"18-24", 25:"25-34", 35:"35+", 90:"35+"} {
+    if b := buckets.Bucket(Known(age)); b != expected {
+      t.Errorf("Expected age %d in bucket %s but found %s", age, expected, b)
+    }
+  }
+  if b := buckets.Bucket(OptionalInt{}); b != AGE_UNKNOWN {
+    t.Errorf("Expected an unknown age in bucket %s but found %s", AGE_UNKNOWN, b)
+  }
+}
+
+func TestDemographics(t *testing.T) {
+  d := USER_WITH_PROFILE_PERSON.Demographics()
+  if d.Gender != GENDER_MALE || d.AgeBucket != "25-34" || d.Country != "US" || d.Region != "NM" {
+    t.Errorf("Unexpected demographics %v", d)
+  }
+  if d.Key() != "male/25-34/US/NM" {
+    t.Errorf("Unexpected demographics key %s", d.Key())
+  }
+  if k := USER_EMPTY_PERSON.Demographics().Key(); k != "unknown/unknown//" {
+    t.Errorf("Unexpected demographics key for an empty person %s", k)
+  }
+}
+
+func TestUnknownGenderKept(t *testing.T) {
+  text := strings.Replace(USER_WITH_PROFILE_XML, "<gender>Male</gender>", "<gender>Robot</gender>", 1)
+  u, warnings, err := DecodePerson(text, ParseOptions{})
+  if err != nil {
+    t.Error("Unexpected error: ", err.String())
+    return
+  }
+  if u.Gender != Gender("robot") || len(warnings) != 1 || warnings[0].Value != "Robot" || !warnings[0].Note {
+    t.Errorf("Expected the lowercased gender on the person and the raw one in a note but found %q and %v", string(u.Gender), warnings)
+  }
+  if d := u.Demographics(); d.Gender != GENDER_UNKNOWN {
+    t.Errorf("Expected unknown gender in demographics but found %q", string(d.Gender))
+  }
+  if strings.Index(u.ToXML(), "<gender>robot</gender>") < 0 {
+    t.Errorf("Expected the kept gender to be written back but found %s", u.ToXML())
+  }
+  if u, _, err = DecodePerson(text, ParseOptions{Strict:true}); err != nil || u == nil {
+    t.Errorf("Expected an unknown gender not to fail a strict parse but found %v", err)
+  }
+}
@@ -0,0 +1,201 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "json"
+  "os"
+  "strconv"
+  "strings"
+  "time"
+)
+
+const (
+  CHANGE_ADDED = "added"
+  CHANGE_REMOVED = "removed"
+  CHANGE_MODIFIED = "modified"
+)
+
+// A Change is one difference between two records of a person.  Path names
+// the field, with occupations keyed by company and title and memberships
+// by site, e.g. "Memberships[twitter.com].NumFollowers".  Old is empty for
+// additions and New for removals.
+type Change struct {
+  Path string "path"
+  Kind string "kind"
+  Old string "old"
+  New string "new"
+}
+
+func (c *Change) String() string {
+  switch c.Kind {
+  case CHANGE_ADDED:
+    return "+ " + c.Path + ": " + strconv.Quote(c.New)
+  case CHANGE_REMOVED:
+    return "- " + c.Path + ": " + strconv.Quote(c.Old)
+  }
+  return "~ " + c.Path + ": " + strconv.Quote(c.Old) + " -> " + strconv.Quote(c.New)
+}
+
+type differ struct {
+  changes []*Change
+}
+
+func (d *differ) add(path, old_value, new_value string) {
+  if old_value == new_value {
+    return
+  }
+  kind := CHANGE_MODIFIED
+  if len(old_value) == 0 {
+    kind = CHANGE_ADDED
+  } else if len(new_value) == 0 {
+    kind = CHANGE_REMOVED
+  }
+  if len(d.changes) == cap(d.changes) {
+    changes := make([]*Change, len(d.changes), 2 * len(d.changes) + 8)
+    copy(changes, d.changes)
+    d.changes = changes
+  }
+  d.changes = d.changes[0:len(d.changes) + 1]
+  d.changes[len(d.changes) - 1] = &Change{Path:path, Kind:kind, Old:old_value, New:new_value}
+}
+
+func diffDate(t *time.Time) string {
+  if t == nil {
+    return ""
+  }
+  return t.Format(dateLayout)
+}
+
+func occupationPath(o *RapleafOccupation) string {
+  return "Occupations[" + o.Company + "/" + o.JobTitle + "]"
+}
+
+func (d *differ) occupations(old_list, new_list []*RapleafOccupation) {
+  old_keys := make(map[string]*RapleafOccupation)
+  new_keys := make(map[string]*RapleafOccupation)
+  // nil entries are skipped, as Merge skips them
+  for _, o := range old_list {
+    if o != nil {
+      old_keys[occupationKey(o)] = o
+    }
+  }
+  for _, o := range new_list {
+    if o != nil {
+      new_keys[occupationKey(o)] = o
+    }
+  }
+  for _, o := range old_list {
+    if o == nil {
+      continue
+    }
+    if _, ok := new_keys[occupationKey(o)]; !ok {
+      d.add(occupationPath(o), o.JobTitle + " at " + o.Company, "")
+    }
+  }
+  for _, o := range new_list {
+    if o == nil {
+      continue
+    }
+    if _, ok := old_keys[occupationKey(o)]; !ok {
+      d.add(occupationPath(o), "", o.JobTitle + " at " + o.Company)
+    }
+  }
+}
+
+func (d *differ) memberSite(path string, old_site, new_site *RapleafMemberSite) {
+  if old_site == nil {
+    old_site = &RapleafMemberSite{}
+  }
+  if new_site == nil {
+    new_site = &RapleafMemberSite{}
+  }
+  d.add(path + ".Exists", old_site.Exists, new_site.Exists)
+  d.add(path + ".ProfileUrl", old_site.ProfileUrl, new_site.ProfileUrl)
+  d.add(path + ".ImageUrl", old_site.ImageUrl, new_site.ImageUrl)
+  d.add(path + ".NumFriends", old_site.NumFriends.String(), new_site.NumFriends.String())
+  d.add(path + ".NumFollowers", old_site.NumFollowers.String(), new_site.NumFollowers.String())
+  d.add(path + ".NumFollowed", old_site.NumFollowed.String(), new_site.NumFollowed.String())
+}
+
+func (d *differ) memberships(old_list, new_list []*RapleafMemberSite) {
+  old_sites := make(map[string]*RapleafMemberSite)
+  new_sites := make(map[string]*RapleafMemberSite)
+  for _, s := range old_list {
+    if s != nil {
+      old_sites[s.Site] = s
+    }
+  }
+  for _, s := range new_list {
+    if s != nil {
+      new_sites[s.Site] = s
+    }
+  }
+  for _, s := range old_list {
+    if s != nil {
+      d.memberSite("Memberships[" + s.Site + "]", s, new_sites[s.Site])
+    }
+  }
+  for _, s := range new_list {
+    if s == nil {
+      continue
+    }
+    if _, ok := old_sites[s.Site]; !ok {
+      d.memberSite("Memberships[" + s.Site + "]", nil, s)
+    }
+  }
+}
+
+// Diff lists what changed from old_person to new_person, field by field.
+// Occupations and memberships are compared as sets, so a reordering is not
+// a change.  Missing records are treated as empty.
+func Diff(old_person, new_person *RapleafPerson) []*Change {
+  if old_person == nil {
+    old_person = &RapleafPerson{}
+  }
+  if new_person == nil {
+    new_person = &RapleafPerson{}
+  }
+  d := &differ{changes:make([]*Change, 0, 8)}
+  d.add("Id", old_person.Id, new_person.Id)
+  d.add("Name", old_person.Name, new_person.Name)
+  d.add("Gender", string(old_person.Gender), string(new_person.Gender))
+  d.add("Location", old_person.Location, new_person.Location)
+  d.add("NumFriends", old_person.NumFriends.String(), new_person.NumFriends.String())
+  d.add("Age", old_person.Age.String(), new_person.Age.String())
+  d.add("EarliestKnownActivity", diffDate(old_person.EarliestKnownActivity), diffDate(new_person.EarliestKnownActivity))
+  d.add("LatestKnownActivity", diffDate(old_person.LatestKnownActivity), diffDate(new_person.LatestKnownActivity))
+  d.add("EmailAddress", old_person.EmailAddress, new_person.EmailAddress)
+  d.occupations(old_person.Occupations, new_person.Occupations)
+  d.memberships(old_person.Memberships, new_person.Memberships)
+  return d.changes
+}
+
+// DiffText renders changes one per line: "+" for additions, "-" for
+// removals and "~" for modifications.
+func DiffText(changes []*Change) string {
+  lines := make([]string, len(changes))
+  for i, c := range changes {
+    lines[i] = c.String() + "\n"
+  }
+  return strings.Join(lines, "")
+}
+
+// DiffJSON renders changes as a JSON array of {path, kind, old, new}.
+func DiffJSON(changes []*Change) ([]byte, os.Error) {
+  return json.Marshal(changes)
+}
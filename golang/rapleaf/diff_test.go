@@ -0,0 +1,90 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "testing"
+)
+
+func TestDiff(t *testing.T) {
+  old_person := USER_WITH_PROFILE_PERSON
+  new_person := *USER_WITH_PROFILE_PERSON
+  new_person.Location = "Santa Fe, New Mexico, United States"
+  new_person.Occupations = []*RapleafOccupation{
+    &RapleafOccupation{Company:"Acme", JobTitle:"CTO"},
+    old_person.Occupations[2],
+    old_person.Occupations[0],
+  }
+  n := len(old_person.Memberships)
+  new_person.Memberships = make([]*RapleafMemberSite, n + 1)
+  // reversed, so only real changes should show up
+  for i, m := range old_person.Memberships {
+    new_person.Memberships[n - 1 - i] = m
+  }
+  twitter := *old_person.Memberships[12]
+  twitter.NumFollowers = Known(20)
+  new_person.Memberships[n - 1 - 12] = &twitter
+  new_person.Memberships[n] = &RapleafMemberSite{Site:"github.com", ProfileUrl:"http://github.com/johnqpublic", Exists:"true"}
+  changes := Diff(old_person, &new_person)
+  expected := []string{
+    "~ Location: \"Albuquerque, New Mexico, United States\" -> \"Santa Fe, New Mexico, United States\"",
+    "- Occupations[GE/VP Marketing]: \"VP Marketing at GE\"",
+    "+ Occupations[Acme/CTO]: \"CTO at Acme\"",
+    "~ Memberships[twitter.com].NumFollowers: \"14\" -> \"20\"",
+    "+ Memberships[github.com].Exists: \"true\"",
+    "+ Memberships[github.com].ProfileUrl: \"http://github.com/johnqpublic\"",
+  }
+  if len(changes) != len(expected) {
+    t.Errorf("Expected %d changes but found:\n%s", len(expected), DiffText(changes))
+    return
+  }
+  for i, c := range changes {
+    if c.String() != expected[i] {
+      t.Errorf("Expected change %s but found %s", expected[i], c.String())
+    }
+  }
+  if changes[3].Kind != CHANGE_MODIFIED || changes[1].Kind != CHANGE_REMOVED || changes[2].Kind != CHANGE_ADDED {
+    t.Errorf("Expected modified, removed and added kinds but found %s, %s and %s", changes[3].Kind, changes[1].Kind, changes[2].Kind)
+  }
+  text, err := DiffJSON(changes[3:4])
+  if err != nil {
+    t.Error("Unable to render changes as JSON: ", err.String())
+    return
+  }
+  if string(text) != "[{\"path\":\"Memberships[twitter.com].NumFollowers\",\"kind\":\"modified\",\"old\":\"14\",\"new\":\"20\"}]" {
+    t.Errorf("Unexpected JSON for changes: %s", text)
+  }
+}
+
+func TestDiffSame(t *testing.T) {
+  if changes := Diff(USER_WITH_PROFILE_PERSON, USER_WITH_PROFILE_PERSON); len(changes) != 0 {
+    t.Errorf("Expected no changes but found:\n%s", DiffText(changes))
+  }
+}
+
+func TestDiffNilEntries(t *testing.T) {
+  old_person := *USER_WITH_PROFILE_PERSON
+  old_person.Occupations = []*RapleafOccupation{nil, USER_WITH_PROFILE_PERSON.Occupations[0]}
+  old_person.Memberships = []*RapleafMemberSite{USER_WITH_PROFILE_PERSON.Memberships[12], nil}
+  new_person := old_person
+  new_person.Occupations = []*RapleafOccupation{USER_WITH_PROFILE_PERSON.Occupations[0], nil}
+  new_person.Memberships = []*RapleafMemberSite{nil, USER_WITH_PROFILE_PERSON.Memberships[12]}
+  if changes := Diff(&old_person, &new_person); len(changes) != 0 {
+    t.Errorf("Expected nil entries to be skipped but found:\n%s", DiffText(changes))
+  }
+}
@@ -0,0 +1,45 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "testing"
+)
+
+func TestGraph(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v2/graph/john.q.public@gmail.com?n=1", "text/plain;charset=UTF-8", "97fc425100000000\nb34282025d7e2c5db6786a8daaab48c7\n")
+  server.AddFixture("/v2/graph/john.q.public@gmail.com?n=2", "text/plain;charset=UTF-8", "john.q.public@gmail.com,empty.profile@gmail.com")
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  ids := RapleafIdsByGraph(API_KEY, "john.q.public@gmail.com")
+  emails := EmailAddressesByGraph(API_KEY, "john.q.public@gmail.com")
+  missing := RapleafIdsByGraph(API_KEY, "nobody@gmail.com")
+  closeServerTestFiles(l)
+  if len(ids) != 2 || ids[0] != "97fc425100000000" || ids[1] != "b34282025d7e2c5db6786a8daaab48c7" {
+    t.Errorf("Expected 2 rapleaf ids but found %v", ids)
+  }
+  if len(emails) != 2 || emails[0] != "john.q.public@gmail.com" || emails[1] != "empty.profile@gmail.com" {
+    t.Errorf("Expected 2 email addresses but found %v", emails)
+  }
+  if missing != nil {
+    t.Errorf("Expected no rapleaf ids for unknown email but found %v", missing)
+  }
+}
@@ -0,0 +1,230 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "crypto/sha1"
+  "fmt"
+  "http"
+  "os"
+  "sync"
+  "time"
+)
+
+const (
+  KEY_ROUND_ROBIN = iota
+  KEY_MOST_REMAINING
+)
+
+var (
+  // ErrNoApiKey is the text of the 403 returned when every key in the pool
+  // is out of quota or has been rejected.
+  ErrNoApiKey = os.NewError("rapleaf: no API key in the pool is available")
+)
+
+type poolKey struct {
+  key string
+  remaining int64
+  resumeAt int64
+  dropped bool
+  requests int64
+}
+
+// A KeyPool spreads lookups over several API keys.  A key that gets a 403
+// sits out until its quota resets; a key that gets a 401 is dropped for
+// good.  Either way the lookup is tried again with the next key.
+type KeyPool struct {
+  // Strategy is KEY_ROUND_ROBIN or KEY_MOST_REMAINING; the latter picks
+  // the key with the most quota left as told through SetRemaining, and
+  // keys whose quota is unknown last.
+  Strategy int
+  // QuotaReset is how long, in nanoseconds, a key sits out after a 403 or
+  // once the quota told through SetRemaining is used up.  Zero means until
+  // the next midnight UTC.
+  QuotaReset int64
+  // OnServe, if set, is called with the KeyId of the key used for every
+  // request and the status it got.
+  OnServe func(key_id string, code int)
+  keys []*poolKey
+  next int
+  lock sync.Mutex
+}
+
+// KeyStats describes one key of a pool; Remaining is -1 when unknown.
+type KeyStats struct {
+  Id string
+  Requests int64
+  Remaining int64
+  Available bool
+  Dropped bool
+}
+
+func NewKeyPool(keys ...string) *KeyPool {
+  p := &KeyPool{keys:make([]*poolKey, len(keys))}
+  for i, key := range keys {
+    p.keys[i] = &poolKey{key:key, remaining:-1}
+  }
+  return p
+}
+
+// KeyId identifies an API key in logs and reports without revealing it:
+// the first 8 hex digits of its SHA-1, so keys sharing a suffix still
+// differ.
+func KeyId(api_key string) string {
+  h := sha1.New()
+  h.Write([]byte(api_key))
+  return fmt.Sprintf("%x", h.Sum())[0:8]
+}
+
+var (
+  keyPool *KeyPool
+  keyPoolLock sync.RWMutex
+)
+
+// SetKeyPool installs p for lookups called with an empty API key; nil
+// removes it.
+func SetKeyPool(p *KeyPool) {
+  keyPoolLock.Lock()
+  keyPool = p
+  keyPoolLock.Unlock()
+}
+
+func currentKeyPool() *KeyPool {
+  keyPoolLock.RLock()
+  defer keyPoolLock.RUnlock()
+  return keyPool
+}
+
+// SetRemaining records how many lookups key has left today.  A key with
+// none left sits out as if it had got a 403.
+func (p *KeyPool) SetRemaining(api_key string, remaining int64) {
+  now := time.Nanoseconds()
+  p.lock.Lock()
+  defer p.lock.Unlock()
+  for _, k := range p.keys {
+    if k.key == api_key {
+      k.remaining = remaining
+      if remaining == 0 {
+        p.rest(k, now)
+      }
+    }
+  }
+}
+
+// rest takes k out of rotation until its quota resets.  Must be called
+// with p.lock held.
+func (p *KeyPool) rest(k *poolKey, now int64) {
+  if p.QuotaReset > 0 {
+    k.resumeAt = now + p.QuotaReset
+  } else {
+    k.resumeAt = (now / (secondsPerDay * 1e9) + 1) * secondsPerDay * 1e9
+  }
+}
+
+func (p *KeyPool) Len() int {
+  return len(p.keys)
+}
+
+func (p *KeyPool) Stats() []*KeyStats {
+  now := time.Nanoseconds()
+  p.lock.Lock()
+  defer p.lock.Unlock()
+  stats := make([]*KeyStats, len(p.keys))
+  for i, k := range p.keys {
+    k.wake(now)
+    stats[i] = &KeyStats{
+      Id:KeyId(k.key),
+      Requests:k.requests,
+      Remaining:k.remaining,
+      Available:k.available(now),
+      Dropped:k.dropped,
+    }
+  }
+  return stats
+}
+
+func (k *poolKey) available(now int64) bool {
+  return !k.dropped && k.resumeAt <= now
+}
+
+// wake forgets the used up quota of a key whose rest is over; how much it
+// has now is unknown.
+func (k *poolKey) wake(now int64) {
+  if k.remaining == 0 && k.resumeAt <= now {
+    k.remaining = -1
+  }
+}
+
+// pick returns the key to use next, or false if none is available.
+func (p *KeyPool) pick() (string, bool) {
+  now := time.Nanoseconds()
+  p.lock.Lock()
+  defer p.lock.Unlock()
+  var chosen *poolKey
+  for _, k := range p.keys {
+    k.wake(now)
+  }
+  if p.Strategy == KEY_MOST_REMAINING {
+    for _, k := range p.keys {
+      if k.available(now) && (chosen == nil || k.remaining > chosen.remaining) {
+        chosen = k
+      }
+    }
+  } else {
+    for i := 0; i < len(p.keys) && chosen == nil; i++ {
+      k := p.keys[(p.next + i) % len(p.keys)]
+      if k.available(now) {
+        chosen = k
+        p.next = (p.next + i + 1) % len(p.keys)
+      }
+    }
+  }
+  if chosen == nil {
+    return "", false
+  }
+  chosen.requests++
+  if chosen.remaining > 0 {
+    chosen.remaining--
+    if chosen.remaining == 0 {
+      p.rest(chosen, now)
+    }
+  }
+  return chosen.key, true
+}
+
+// report takes key out of rotation if the API refused it.
+func (p *KeyPool) report(api_key string, code int) {
+  now := time.Nanoseconds()
+  p.lock.Lock()
+  for _, k := range p.keys {
+    if k.key != api_key {
+      continue
+    }
+    switch code {
+    case http.StatusUnauthorized:
+      k.dropped = true
+    case http.StatusForbidden:
+      p.rest(k, now)
+      k.remaining = -1
+    }
+  }
+  callback := p.OnServe
+  p.lock.Unlock()
+  if callback != nil {
+    callback(KeyId(api_key), code)
+  }
+}
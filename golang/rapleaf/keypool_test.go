@@ -0,0 +1,117 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "http"
+  "strconv"
+  "testing"
+  "time"
+)
+
+func TestKeyPoolDropsRevokedKey(t *testing.T) {
+  l, err := serveTestFiles(t)
+  if err != nil {
+    return
+  }
+  pool := NewKeyPool("revoked-key-0001", API_KEY)
+  served := make([]string, 0, 4)
+  pool.OnServe = func(key_id string, code int) {
+    served = served[0:len(served) + 1]
+    served[len(served) - 1] = key_id + " " + strconv.Itoa(code)
+  }
+  SetKeyPool(pool)
+  code, text := PersonXmlByEmail("", "john.q.public@gmail.com")
+  code2, _ := PersonXmlByEmail("", "john.q.public@gmail.com")
+  SetKeyPool(nil)
+  closeServerTestFiles(l)
+  if code != http.StatusOK || code2 != http.StatusOK {
+    t.Error("Expected status code 200 from the second key but received ", code, " with message: ", text)
+  }
+  expected := []string{"ec88f5dc 401", "5eee3838 200", "5eee3838 200"}
+  if len(served) != len(expected) {
+    t.Errorf("Expected keys served %v but found %v", expected, served)
+    return
+  }
+  for i, s := range expected {
+    if served[i] != s {
+      t.Errorf("Expected key served %s but found %s", s, served[i])
+    }
+  }
+  stats := pool.Stats()
+  if !stats[0].Dropped || stats[0].Available || stats[0].Requests != 1 {
+    t.Errorf("Expected revoked key to be dropped after 1 request but found %v", stats[0])
+  }
+  if stats[1].Dropped || !stats[1].Available || stats[1].Requests != 2 {
+    t.Errorf("Expected good key to stay available after 2 requests but found %v", stats[1])
+  }
+}
+
+func TestKeyPoolRestsExhaustedKey(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.Quota = 1
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  SetKeyPool(NewKeyPool(API_KEY))
+  code1, _ := PersonXmlByEmail("", "john.q.public@gmail.com")
+  code2, _ := PersonXmlByEmail("", "john.q.public@gmail.com")
+  code3, text3 := PersonXmlByEmail("", "john.q.public@gmail.com")
+  SetKeyPool(nil)
+  closeServerTestFiles(l)
+  if code1 != http.StatusOK || code2 != http.StatusForbidden {
+    t.Errorf("Expected status codes 200 then 403 but received %d and %d", code1, code2)
+  }
+  if code3 != http.StatusForbidden || text3 != ErrNoApiKey.String() {
+    t.Errorf("Expected %s but received %d: %s", ErrNoApiKey.String(), code3, text3)
+  }
+  if server.Calls() != 2 {
+    t.Errorf("Expected the exhausted key not to be sent again but found %d calls", server.Calls())
+  }
+}
+
+func TestKeyPoolServesKeyAfterQuotaReset(t *testing.T) {
+  l, err := serveTestFiles(t)
+  if err != nil {
+    return
+  }
+  pool := NewKeyPool(API_KEY)
+  pool.QuotaReset = 50e6
+  pool.SetRemaining(API_KEY, 1)
+  SetKeyPool(pool)
+  code1, _ := PersonXmlByEmail("", "john.q.public@gmail.com")
+  code2, text2 := PersonXmlByEmail("", "john.q.public@gmail.com")
+  time.Sleep(100e6)
+  code3, _ := PersonXmlByEmail("", "john.q.public@gmail.com")
+  SetKeyPool(nil)
+  closeServerTestFiles(l)
+  if code1 != http.StatusOK {
+    t.Errorf("Expected status code 200 from the key's last lookup but received %d", code1)
+  }
+  if code2 != http.StatusForbidden || text2 != ErrNoApiKey.String() {
+    t.Errorf("Expected %s once the quota was used up but received %d: %s", ErrNoApiKey.String(), code2, text2)
+  }
+  if code3 != http.StatusOK {
+    t.Errorf("Expected the key to be served again after the reset but received %d", code3)
+  }
+  if stats := pool.Stats(); stats[0].Remaining != -1 || !stats[0].Available {
+    t.Errorf("Expected an available key with unknown quota after the reset but found %v", stats[0])
+  }
+}
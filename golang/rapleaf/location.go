@@ -0,0 +1,509 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "strings"
+)
+
+// A ParsedLocation splits the free text of RapleafPerson.Location into
+// city, region and country using a table bundled with the package, so no
+// lookup service is needed.  Codes are ISO 3166-1 alpha-2 for countries
+// and the local postal or ISO 3166-2 suffix for regions, e.g. "NM".
+// Confidence runs from 0, nothing recognized, to 1, a known country and
+// region with a city before them.
+type ParsedLocation struct {
+  City string
+  Region string
+  Country string
+  RegionCode string
+  CountryCode string
+  Confidence float64
+  Raw string
+}
+
+type locationName struct {
+  code string
+  name string
+  aliases []string
+}
+
+var (
+  locationCountries = []locationName{
+    locationName{"AD", "Andorra", nil},
+    locationName{"AE", "United Arab Emirates", []string{"UAE"}},
+    locationName{"AF", "Afghanistan", nil},
+    locationName{"AG", "Antigua and Barbuda", nil},
+    locationName{"AL", "Albania", nil},
+    locationName{"AM", "Armenia", nil},
+    locationName{"AO", "Angola", nil},
+    locationName{"AR", "Argentina", nil},
+    locationName{"AT", "Austria", nil},
+    locationName{"AU", "Australia", nil},
+    locationName{"AW", "Aruba", nil},
+    locationName{"AZ", "Azerbaijan", nil},
+    locationName{"BA", "Bosnia and Herzegovina", []string{"Bosnia"}},
+    locationName{"BB", "Barbados", nil},
+    locationName{"BD", "Bangladesh", nil},
+    locationName{"BE", "Belgium", nil},
+    locationName{"BF", "Burkina Faso", nil},
+    locationName{"BG", "Bulgaria", nil},
+    locationName{"BH", "Bahrain", nil},
+    locationName{"BI", "Burundi", nil},
+    locationName{"BJ", "Benin", nil},
+    locationName{"BM", "Bermuda", nil},
+    locationName{"BN", "Brunei", nil},
+    locationName{"BO", "Bolivia", nil},
+    locationName{"BR", "Brazil", []string{"Brasil"}},
+    locationName{"BS", "Bahamas", []string{"The Bahamas"}},
+    locationName{"BT", "Bhutan", nil},
+    locationName{"BW", "Botswana", nil},
+    locationName{"BY", "Belarus", nil},
+    locationName{"BZ", "Belize", nil},
+    locationName{"CA", "Canada", nil},
+    locationName{"CD", "Democratic Republic of the Congo", []string{"DR Congo"}},
+    locationName{"CF", "Central African Republic", nil},
+    locationName{"CG", "Republic of the Congo", []string{"Congo"}},
+    locationName{"CH", "Switzerland", nil},
+    locationName{"CI", "Côte d'Ivoire", []string{"Cote d'Ivoire", "Ivory Coast"}},
+    locationName{"CL", "Chile", nil},
+    locationName{"CM", "Cameroon", nil},
+    locationName{"CN", "China", []string{"People's Republic of China", "PRC"}},
+    locationName{"CO", "Colombia", nil},
+    locationName{"CR", "Costa Rica", nil},
+    locationName{"CU", "Cuba", nil},
+    locationName{"CV", "Cape Verde", nil},
+    locationName{"CY", "Cyprus", nil},
+    locationName{"CZ", "Czech Republic", []string{"Czechia"}},
+    locationName{"DE", "Germany", []string{"Deutschland"}},
+    locationName{"DJ", "Djibouti", nil},
+    locationName{"DK", "Denmark", nil},
+    locationName{"DM", "Dominica", nil},
+    locationName{"DO", "Dominican Republic", nil},
+    locationName{"DZ", "Algeria", nil},
+    locationName{"EC", "Ecuador", nil},
+    locationName{"EE", "Estonia", nil},
+    locationName{"EG", "Egypt", nil},
+    locationName{"ER", "Eritrea", nil},
+    locationName{"ES", "Spain", []string{"España"}},
+    locationName{"ET", "Ethiopia", nil},
+    locationName{"FI", "Finland", nil},
+    locationName{"FJ", "Fiji", nil},
+    locationName{"FM", "Micronesia", nil},
+    locationName{"FO", "Faroe Islands", nil},
+    locationName{"FR", "France", nil},
+    locationName{"GA", "Gabon", nil},
+    locationName{"GB", "United Kingdom", []string{"UK", "Great Britain", "England", "Scotland", "Wales", "Northern Ireland"}},
+    locationName{"GD", "Grenada", nil},
+    locationName{"GE", "Georgia", nil},
+    locationName{"GG", "Guernsey", nil},
+    locationName{"GH", "Ghana", nil},
+    locationName{"GI", "Gibraltar", nil},
+    locationName{"GL", "Greenland", nil},
+    locationName{"GM", "Gambia", []string{"The Gambia"}},
+    locationName{"GN", "Guinea", nil},
+    locationName{"GQ", "Equatorial Guinea", nil},
+    locationName{"GR", "Greece", nil},
+    locationName{"GT", "Guatemala", nil},
+    locationName{"GW", "Guinea-Bissau", nil},
+    locationName{"GY", "Guyana", nil},
+    locationName{"HK", "Hong Kong", nil},
+    locationName{"HN", "Honduras", nil},
+    locationName{"HR", "Croatia", nil},
+    locationName{"HT", "Haiti", nil},
+    locationName{"HU", "Hungary", nil},
+    locationName{"ID", "Indonesia", nil},
+    locationName{"IE", "Ireland", nil},
+    locationName{"IL", "Israel", nil},
+    locationName{"IM", "Isle of Man", nil},
+    locationName{"IN", "India", nil},
+    locationName{"IQ", "Iraq", nil},
+    locationName{"IR", "Iran", nil},
+    locationName{"IS", "Iceland", nil},
+    locationName{"IT", "Italy", []string{"Italia"}},
+    locationName{"JE", "Jersey", nil},
+    locationName{"JM", "Jamaica", nil},
+    locationName{"JO", "Jordan", nil},
+    locationName{"JP", "Japan", nil},
+    locationName{"KE", "Kenya", nil},
+    locationName{"KG", "Kyrgyzstan", nil},
+    locationName{"KH", "Cambodia", nil},
+    locationName{"KI", "Kiribati", nil},
+    locationName{"KM", "Comoros", nil},
+    locationName{"KN", "Saint Kitts and Nevis", nil},
+    locationName{"KP", "North Korea", nil},
+    locationName{"KR", "South Korea", []string{"Korea", "Republic of Korea"}},
+    locationName{"KW", "Kuwait", nil},
+    locationName{"KY", "Cayman Islands", nil},
+    locationName{"KZ", "Kazakhstan", nil},
+    locationName{"LA", "Laos", nil},
+    locationName{"LB", "Lebanon", nil},
+    locationName{"LC", "Saint Lucia", nil},
+    locationName{"LI", "Liechtenstein", nil},
+    locationName{"LK", "Sri Lanka", nil},
+    locationName{"LR", "Liberia", nil},
+    locationName{"LS", "Lesotho", nil},
+    locationName{"LT", "Lithuania", nil},
+    locationName{"LU", "Luxembourg", nil},
+    locationName{"LV", "Latvia", nil},
+    locationName{"LY", "Libya", nil},
+    locationName{"MA", "Morocco", nil},
+    locationName{"MC", "Monaco", nil},
+    locationName{"MD", "Moldova", nil},
+    locationName{"ME", "Montenegro", nil},
+    locationName{"MG", "Madagascar", nil},
+    locationName{"MH", "Marshall Islands", nil},
+    locationName{"MK", "Macedonia", nil},
+    locationName{"ML", "Mali", nil},
+    locationName{"MM", "Myanmar", []string{"Burma"}},
+    locationName{"MN", "Mongolia", nil},
+    locationName{"MO", "Macau", []string{"Macao"}},
+    locationName{"MR", "Mauritania", nil},
+    locationName{"MT", "Malta", nil},
+    locationName{"MU", "Mauritius", nil},
+    locationName{"MV", "Maldives", nil},
+    locationName{"MW", "Malawi", nil},
+    locationName{"MX", "Mexico", []string{"México"}},
+    locationName{"MY", "Malaysia", nil},
+    locationName{"MZ", "Mozambique", nil},
+    locationName{"NA", "Namibia", nil},
+    locationName{"NE", "Niger", nil},
+    locationName{"NG", "Nigeria", nil},
+    locationName{"NI", "Nicaragua", nil},
+    locationName{"NL", "Netherlands", []string{"The Netherlands", "Holland"}},
+    locationName{"NO", "Norway", nil},
+    locationName{"NP", "Nepal", nil},
+    locationName{"NR", "Nauru", nil},
+    locationName{"NZ", "New Zealand", nil},
+    locationName{"OM", "Oman", nil},
+    locationName{"PA", "Panama", nil},
+    locationName{"PE", "Peru", nil},
+    locationName{"PG", "Papua New Guinea", nil},
+    locationName{"PH", "Philippines", nil},
+    locationName{"PK", "Pakistan", nil},
+    locationName{"PL", "Poland", nil},
+    locationName{"PS", "Palestine", nil},
+    locationName{"PT", "Portugal", nil},
+    locationName{"PW", "Palau", nil},
+    locationName{"PY", "Paraguay", nil},
+    locationName{"QA", "Qatar", nil},
+    locationName{"RO", "Romania", nil},
+    locationName{"RS", "Serbia", nil},
+    locationName{"RU", "Russia", []string{"Russian Federation"}},
+    locationName{"RW", "Rwanda", nil},
+    locationName{"SA", "Saudi Arabia", nil},
+    locationName{"SB", "Solomon Islands", nil},
+    locationName{"SC", "Seychelles", nil},
+    locationName{"SD", "Sudan", nil},
+    locationName{"SE", "Sweden", nil},
+    locationName{"SG", "Singapore", nil},
+    locationName{"SI", "Slovenia", nil},
+    locationName{"SK", "Slovakia", nil},
+    locationName{"SL", "Sierra Leone", nil},
+    locationName{"SM", "San Marino", nil},
+    locationName{"SN", "Senegal", nil},
+    locationName{"SO", "Somalia", nil},
+    locationName{"SR", "Suriname", nil},
+    locationName{"ST", "São Tomé and Príncipe", []string{"Sao Tome and Principe"}},
+    locationName{"SV", "El Salvador", nil},
+    locationName{"SY", "Syria", nil},
+    locationName{"SZ", "Swaziland", nil},
+    locationName{"TD", "Chad", nil},
+    locationName{"TG", "Togo", nil},
+    locationName{"TH", "Thailand", nil},
+    locationName{"TJ", "Tajikistan", nil},
+    locationName{"TL", "East Timor", []string{"Timor-Leste"}},
+    locationName{"TM", "Turkmenistan", nil},
+    locationName{"TN", "Tunisia", nil},
+    locationName{"TO", "Tonga", nil},
+    locationName{"TR", "Turkey", nil},
+    locationName{"TT", "Trinidad and Tobago", nil},
+    locationName{"TV", "Tuvalu", nil},
+    locationName{"TW", "Taiwan", nil},
+    locationName{"TZ", "Tanzania", nil},
+    locationName{"UA", "Ukraine", nil},
+    locationName{"UG", "Uganda", nil},
+    locationName{"US", "United States", []string{"USA", "United States of America"}},
+    locationName{"UY", "Uruguay", nil},
+    locationName{"UZ", "Uzbekistan", nil},
+    locationName{"VA", "Vatican City", []string{"Holy See"}},
+    locationName{"VC", "Saint Vincent and the Grenadines", nil},
+    locationName{"VE", "Venezuela", nil},
+    locationName{"VN", "Vietnam", []string{"Viet Nam"}},
+    locationName{"VU", "Vanuatu", nil},
+    locationName{"WS", "Samoa", nil},
+    locationName{"YE", "Yemen", nil},
+    locationName{"ZA", "South Africa", nil},
+    locationName{"ZM", "Zambia", nil},
+    locationName{"ZW", "Zimbabwe", nil},
+  }
+  locationRegions = map[string][]locationName{
+    "US":[]locationName{
+      locationName{"AL", "Alabama", nil},
+      locationName{"AK", "Alaska", nil},
+      locationName{"AZ", "Arizona", nil},
+      locationName{"AR", "Arkansas", nil},
+      locationName{"CA", "California", nil},
+      locationName{"CO", "Colorado", nil},
+      locationName{"CT", "Connecticut", nil},
+      locationName{"DE", "Delaware", nil},
+      locationName{"DC", "District of Columbia", []string{"Washington DC", "Washington D.C."}},
+      locationName{"FL", "Florida", nil},
+      locationName{"GA", "Georgia", nil},
+      locationName{"HI", "Hawaii", nil},
+      locationName{"ID", "Idaho", nil},
+      locationName{"IL", "Illinois", nil},
+      locationName{"IN", "Indiana", nil},
+      locationName{"IA", "Iowa", nil},
+      locationName{"KS", "Kansas", nil},
+      locationName{"KY", "Kentucky", nil},
+      locationName{"LA", "Louisiana", nil},
+      locationName{"ME", "Maine", nil},
+      locationName{"MD", "Maryland", nil},
+      locationName{"MA", "Massachusetts", nil},
+      locationName{"MI", "Michigan", nil},
+      locationName{"MN", "Minnesota", nil},
+      locationName{"MS", "Mississippi", nil},
+      locationName{"MO", "Missouri", nil},
+      locationName{"MT", "Montana", nil},
+      locationName{"NE", "Nebraska", nil},
+      locationName{"NV", "Nevada", nil},
+      locationName{"NH", "New Hampshire", nil},
+      locationName{"NJ", "New Jersey", nil},
+      locationName{"NM", "New Mexico", nil},
+      locationName{"NY", "New York", nil},
+      locationName{"NC", "North Carolina", nil},
+      locationName{"ND", "North Dakota", nil},
+      locationName{"OH", "Ohio", nil},
+      locationName{"OK", "Oklahoma", nil},
+      locationName{"OR", "Oregon", nil},
+      locationName{"PA", "Pennsylvania", nil},
+      locationName{"PR", "Puerto Rico", nil},
+      locationName{"RI", "Rhode Island", nil},
+      locationName{"SC", "South Carolina", nil},
+      locationName{"SD", "South Dakota", nil},
+      locationName{"TN", "Tennessee", nil},
+      locationName{"TX", "Texas", nil},
+      locationName{"UT", "Utah", nil},
+      locationName{"VT", "Vermont", nil},
+      locationName{"VA", "Virginia", nil},
+      locationName{"WA", "Washington", nil},
+      locationName{"WV", "West Virginia", nil},
+      locationName{"WI", "Wisconsin", nil},
+      locationName{"WY", "Wyoming", nil},
+    },
+    "CA":[]locationName{
+      locationName{"AB", "Alberta", nil},
+      locationName{"BC", "British Columbia", nil},
+      locationName{"MB", "Manitoba", nil},
+      locationName{"NB", "New Brunswick", nil},
+      locationName{"NL", "Newfoundland and Labrador", []string{"Newfoundland"}},
+      locationName{"NS", "Nova Scotia", nil},
+      locationName{"NT", "Northwest Territories", nil},
+      locationName{"NU", "Nunavut", nil},
+      locationName{"ON", "Ontario", nil},
+      locationName{"PE", "Prince Edward Island", nil},
+      locationName{"QC", "Quebec", []string{"Québec"}},
+      locationName{"SK", "Saskatchewan", nil},
+      locationName{"YT", "Yukon", nil},
+    },
+    "AU":[]locationName{
+      locationName{"ACT", "Australian Capital Territory", nil},
+      locationName{"NSW", "New South Wales", nil},
+      locationName{"NT", "Northern Territory", nil},
+      locationName{"QLD", "Queensland", nil},
+      locationName{"SA", "South Australia", nil},
+      locationName{"TAS", "Tasmania", nil},
+      locationName{"VIC", "Victoria", nil},
+      locationName{"WA", "Western Australia", nil},
+    },
+  }
+)
+
+// locationCountryCities settles a country name that is also a region's:
+// after one of these cities "Georgia" is the country, after any other city
+// the state.
+var locationCountryCities = map[string][]string{
+  "GE": []string{"Tbilisi", "Batumi", "Kutaisi", "Rustavi", "Zugdidi", "Gori", "Poti"},
+}
+
+// locationIndex maps lowercased names and aliases, and codes as written,
+// to table entries.
+type locationIndex map[string]*locationName
+
+func newLocationIndex(names []locationName) locationIndex {
+  index := make(locationIndex)
+  for i, _ := range names {
+    n := &names[i]
+    index[n.code] = n
+    index[strings.ToLower(n.name)] = n
+    for _, alias := range n.aliases {
+      index[strings.ToLower(alias)] = n
+    }
+  }
+  return index
+}
+
+func (index locationIndex) find(s string) *locationName {
+  // codes only match in upper case, so "in" or "me" in running text is
+  // not taken for a country or state
+  if n, ok := index[s]; ok && s == n.code {
+    return n
+  }
+  if n, ok := index[strings.ToLower(s)]; ok {
+    return n
+  }
+  return nil
+}
+
+var (
+  countryIndex = newLocationIndex(locationCountries)
+  regionIndexes = make(map[string]locationIndex)
+)
+
+func init() {
+  for code, regions := range locationRegions {
+    regionIndexes[code] = newLocationIndex(regions)
+  }
+}
+
+func isRegionCode(code string) bool {
+  for _, index := range regionIndexes {
+    if r := index.find(code); r != nil && r.code == code {
+      return true
+    }
+  }
+  return false
+}
+
+func isRegionName(name string) bool {
+  for _, index := range regionIndexes {
+    if r := index.find(name); r != nil && r.code != name {
+      return true
+    }
+  }
+  return false
+}
+
+func isCountryCity(country_code, city string) bool {
+  for _, c := range locationCountryCities[country_code] {
+    if strings.ToLower(c) == strings.ToLower(city) {
+      return true
+    }
+  }
+  return false
+}
+
+// ParseLocation reads text of the form "City, Region, Country", any part of
+// which may be missing.  A region without a country is looked up in every
+// country's table and accepted only if it names one region.  A code that
+// could be a region or a country is read as a region when something comes
+// before it, so "Chicago, IL" is in Illinois, not Israel; the code alone,
+// or the country's full name, is read as the country.  A name shared by a
+// country and a region, "Georgia", is the region after a city unless the
+// city is known to be in the country, so "Atlanta, Georgia" is the state
+// and "Tbilisi, Georgia" the country.  Text between the city and a known
+// country that is not in the country's table, or that has no table, is
+// kept as the Region without a code.
+func ParseLocation(location string) *ParsedLocation {
+  p := &ParsedLocation{Raw:location}
+  parts := strings.Split(location, ",", -1)
+  n := 0
+  for _, part := range parts {
+    if part = strings.TrimSpace(part); len(part) > 0 {
+      parts[n] = part
+      n++
+    }
+  }
+  parts = parts[0:n]
+  if len(parts) == 0 {
+    return p
+  }
+  placed := 0
+  inferred := false
+  uncoded := false
+  last := parts[len(parts) - 1]
+  c := countryIndex.find(last)
+  if c != nil && last == c.code && len(parts) > 1 && isRegionCode(last) {
+    // after a city, "CA" is California rather than Canada
+    c = nil
+  } else if c != nil && last != c.code && len(parts) > 1 && isRegionName(last) && !isCountryCity(c.code, parts[0]) {
+    c = nil
+  }
+  if c != nil {
+    p.Country, p.CountryCode = c.name, c.code
+    parts = parts[0:len(parts) - 1]
+    placed++
+  }
+  if len(parts) > 0 {
+    region := parts[len(parts) - 1]
+    var r *locationName
+    if len(p.CountryCode) > 0 {
+      if index, ok := regionIndexes[p.CountryCode]; ok {
+        r = index.find(region)
+      }
+    } else {
+      matches := 0
+      for code, index := range regionIndexes {
+        if found := index.find(region); found != nil {
+          r = found
+          c := countryIndex.find(code)
+          p.Country, p.CountryCode = c.name, c.code
+          matches++
+        }
+      }
+      if matches > 1 {
+        r = nil
+        p.Country, p.CountryCode = "", ""
+      }
+      inferred = r != nil
+    }
+    if r != nil {
+      p.Region, p.RegionCode = r.name, r.code
+      parts = parts[0:len(parts) - 1]
+      placed++
+    } else if len(p.CountryCode) > 0 && len(parts) > 1 {
+      p.Region = region
+      parts = parts[0:len(parts) - 1]
+      uncoded = true
+    }
+  }
+  if len(parts) > 0 {
+    p.City = parts[0]
+  }
+  // each recognized part is worth 0.4 and a city 0.2; a country taken
+  // from the region alone counts for half, as does a region kept without a
+  // code, and text that fits nowhere costs 0.2 per part
+  p.Confidence = 0.4 * float64(placed)
+  if inferred || uncoded {
+    p.Confidence += 0.2
+  }
+  if len(p.City) > 0 && placed > 0 {
+    p.Confidence += 0.2
+  }
+  if len(parts) > 1 {
+    p.Confidence -= 0.2 * float64(len(parts) - 1)
+  }
+  if p.Confidence < 0 {
+    p.Confidence = 0
+  }
+  return p
+}
+
+func (p *RapleafPerson) ParsedLocation() *ParsedLocation {
+  return ParseLocation(p.Location)
+}
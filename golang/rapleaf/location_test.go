@@ -0,0 +1,61 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "testing"
+)
+
+func TestParseLocation(t *testing.T) {
+  tests := []struct {
+    raw, city, region, region_code, country, country_code string
+    confidence float64
+  }{
+    {"Albuquerque, New Mexico, United States", "Albuquerque", "New Mexico", "NM", "United States", "US", 1},
+    {"Albuquerque, NM", "Albuquerque", "New Mexico", "NM", "United States", "US", 0.8},
+    {"Toronto, Ontario, canada", "Toronto", "Ontario", "ON", "Canada", "CA", 1},
+    {"Paris, France", "Paris", "", "", "France", "FR", 0.6},
+    {"Los Angeles, CA", "Los Angeles", "California", "CA", "United States", "US", 0.8},
+    {"Chicago, IL", "Chicago", "Illinois", "IL", "United States", "US", 0.8},
+    {"Adelaide, SA", "Adelaide", "South Australia", "SA", "Australia", "AU", 0.8},
+    {"Tel Aviv, Israel", "Tel Aviv", "", "", "Israel", "IL", 0.6},
+    {"CA", "", "", "", "Canada", "CA", 0.4},
+    {"Accra, Ghana", "Accra", "", "", "Ghana", "GH", 0.6},
+    {"Perth, WA", "Perth", "", "", "", "", 0},
+    {"Paris, Ile-de-France, France", "Paris", "Ile-de-France", "", "France", "FR", 0.8},
+    {"Austin, Texsa, United States", "Austin", "Texsa", "", "United States", "US", 0.8},
+    {"Atlanta, Georgia", "Atlanta", "Georgia", "GA", "United States", "US", 0.8},
+    {"Tbilisi, Georgia", "Tbilisi", "", "", "Georgia", "GE", 0.6},
+    {"Georgia", "", "", "", "Georgia", "GE", 0.4},
+    {"Springfield", "Springfield", "", "", "", "", 0},
+    {"", "", "", "", "", "", 0},
+  }
+  for _, test := range tests {
+    p := ParseLocation(test.raw)
+    if p.Raw != test.raw || p.City != test.city || p.Region != test.region || p.RegionCode != test.region_code ||
+        p.Country != test.country || p.CountryCode != test.country_code {
+      t.Errorf("Unexpected parse of %q: %v", test.raw, p)
+    }
+    if d := p.Confidence - test.confidence; d > 0.001 || d < -0.001 {
+      t.Errorf("Expected confidence %f for %q but found %f", test.confidence, test.raw, p.Confidence)
+    }
+  }
+  if p := USER_WITH_PROFILE_PERSON.ParsedLocation(); p.CountryCode != "US" || p.RegionCode != "NM" {
+    t.Errorf("Expected a location in New Mexico, United States but found %v", p)
+  }
+}
@@ -0,0 +1,147 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "fmt"
+  "io"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// An Exchange describes one request made to the API.  Emails in Url and the
+// API key are masked unless the logger was installed with ShowPII; a masked
+// key is given as its KeyId, as in traces and KeyPool reports.
+type Exchange struct {
+  Method string
+  Url string
+  Endpoint string
+  Caller string
+  Key string
+  Status int
+  // Latency is in nanoseconds
+  Latency int64
+  // Attempts counts this request and the ones made before it for the same
+  // lookup, one per key tried from the key pool
+  Attempts int
+  // CacheHit is set when a StoreLookup answered from its store and no
+  // request went out
+  CacheHit bool
+  // Bytes is the length of the body as received, even when the caller is
+  // given an error in its place
+  Bytes int
+  // Body is only set when the logger was installed with Bodies
+  Body string
+}
+
+type ExchangeLogger func(e *Exchange)
+
+type LogOptions struct {
+  // ShowPII logs emails and API keys as they are
+  ShowPII bool
+  // Bodies logs each response body, for debugging
+  Bodies bool
+}
+
+var (
+  exchangeLogger ExchangeLogger
+  logOptions LogOptions
+  logLock sync.RWMutex
+)
+
+// SetExchangeLogger installs logger to be called once for every request
+// made to the API; nil turns logging off.
+func SetExchangeLogger(logger ExchangeLogger, options LogOptions) {
+  logLock.Lock()
+  exchangeLogger = logger
+  logOptions = options
+  logLock.Unlock()
+}
+
+// NewExchangeWriter returns an ExchangeLogger writing one line of key=value
+// pairs per exchange to w.
+func NewExchangeWriter(w io.Writer) ExchangeLogger {
+  var lock sync.Mutex
+  return func(e *Exchange) {
+    cache := "miss"
+    if e.CacheHit {
+      cache = "hit"
+    }
+    line := fmt.Sprintf("time=%s level=INFO msg=\"rapleaf exchange\" method=%s url=%s endpoint=%s caller=%s key=%s status=%d latency=%dms attempts=%d cache=%s bytes=%d",
+      time.UTC().Format("2006-01-02T15:04:05Z"),
+      e.Method, strconv.Quote(e.Url), e.Endpoint, strconv.Quote(e.Caller),
+      strconv.Quote(e.Key), e.Status, e.Latency / 1e6, e.Attempts, cache, e.Bytes)
+    if len(e.Body) > 0 {
+      line += " body=" + strconv.Quote(e.Body)
+    }
+    lock.Lock()
+    io.WriteString(w, line + "\n")
+    lock.Unlock()
+  }
+}
+
+func logExchange(e *Exchange, api_key, body string) {
+  logLock.RLock()
+  logger := exchangeLogger
+  options := logOptions
+  logLock.RUnlock()
+  if logger == nil {
+    return
+  }
+  if options.ShowPII {
+    e.Key = api_key
+  } else {
+    e.Key = KeyId(api_key)
+    e.Url = redactUrl(e.Url)
+  }
+  if options.Bodies {
+    e.Body = body
+  }
+  logger(e)
+}
+
+// redactEmail keeps the first letter of the mailbox and the domain.
+func redactEmail(email string) string {
+  i := strings.Index(email, "@")
+  if i < 0 {
+    return email
+  }
+  if i == 0 {
+    return "***" + email[i:]
+  }
+  return email[0:1] + "***" + email[i:]
+}
+
+// redactUrl masks every path segment holding an email address, escaped or
+// not.
+func redactUrl(url string) string {
+  query := ""
+  if i := strings.Index(url, "?"); i >= 0 {
+    url, query = url[0:i], url[i:]
+  }
+  segments := strings.Split(url, "/", -1)
+  for i, segment := range segments {
+    if j := strings.Index(strings.ToUpper(segment), "%40"); j >= 0 {
+      segments[i] = redactEmail(segment[0:j] + "@" + segment[j + 3:])
+    } else if strings.Index(segment, "@") >= 0 {
+      segments[i] = redactEmail(segment)
+    }
+  }
+  return strings.Join(segments, "/") + query
+}
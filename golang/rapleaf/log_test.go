@@ -0,0 +1,136 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "bytes"
+  "http"
+  "os"
+  "strings"
+  "testing"
+)
+
+func TestExchangeLoggerMasksEmailAndKey(t *testing.T) {
+  l, err := serveTestFiles(t)
+  if err != nil {
+    return
+  }
+  var found *Exchange
+  SetExchangeLogger(func(e *Exchange) { found = e }, LogOptions{})
+  PersonXmlByEmail(API_KEY, "john.q.public@gmail.com")
+  SetExchangeLogger(nil, LogOptions{})
+  closeServerTestFiles(l)
+  if found == nil {
+    t.Error("Expected an exchange to be logged")
+    return
+  }
+  if strings.Index(found.Url, "john.q.public") >= 0 || strings.Index(found.Url, "/v3/person/email/j***@gmail.com") < 0 {
+    t.Errorf("Expected masked email in url but found %s", found.Url)
+  }
+  if found.Key != KeyId(API_KEY) || found.Key == API_KEY {
+    t.Errorf("Expected the API key's id %s but found %s", KeyId(API_KEY), found.Key)
+  }
+  if found.Method != "GET" || found.Endpoint != ENDPOINT_EMAIL || found.Status != http.StatusOK {
+    t.Errorf("Expected GET email lookup with status 200 but found %s %s %d", found.Method, found.Endpoint, found.Status)
+  }
+  if found.Bytes < len(USER_WITH_PROFILE_XML) || len(found.Body) != 0 {
+    t.Errorf("Expected at least %d bytes and no body but found %d bytes and body %q", len(USER_WITH_PROFILE_XML), found.Bytes, found.Body)
+  }
+}
+
+func TestExchangeLoggerDebug(t *testing.T) {
+  l, err := serveTestFiles(t)
+  if err != nil {
+    return
+  }
+  var found *Exchange
+  SetExchangeLogger(func(e *Exchange) { found = e }, LogOptions{ShowPII:true, Bodies:true})
+  CallerTag("reports").PersonXmlBySite(API_KEY, "linkedin", "johnqpublic")
+  SetExchangeLogger(nil, LogOptions{})
+  closeServerTestFiles(l)
+  if found == nil {
+    t.Error("Expected an exchange to be logged")
+    return
+  }
+  if found.Key != API_KEY || found.Caller != "reports" {
+    t.Errorf("Expected key %s and caller reports but found %s and %s", API_KEY, found.Key, found.Caller)
+  }
+  if strings.TrimSpace(found.Body) != USER_WITH_PROFILE_XML {
+    t.Errorf("Expected response body to be logged but found %q", found.Body)
+  }
+}
+
+func TestExchangeLoggerRefusedBody(t *testing.T) {
+  portal := "<html><body>" + strings.Repeat("x", 200) + "</body></html>"
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "text/html", portal)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  var found *Exchange
+  SetExchangeLogger(func(e *Exchange) { found = e }, LogOptions{})
+  PersonXmlByEmail(API_KEY, "john.q.public@gmail.com")
+  SetExchangeLogger(nil, LogOptions{})
+  closeServerTestFiles(l)
+  if found == nil || found.Status != 502 || found.Bytes != len(portal) || found.Attempts != 1 {
+    t.Errorf("Expected a 502 after one attempt counting the %d bytes received but found %v", len(portal), found)
+  }
+}
+
+func TestExchangeLoggerStoreHit(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  defer closeServerTestFiles(l)
+  filename := testStoreFile()
+  defer os.Remove(filename)
+  s, err := OpenFileStore(filename)
+  if err != nil {
+    t.Error("Unable to open store: ", err.String())
+    return
+  }
+  defer s.Close()
+  lookup := NewStoreLookup(s, API_KEY, 3600)
+  found := make([]*Exchange, 0, 4)
+  SetExchangeLogger(func(e *Exchange) { found = found[0:len(found) + 1]; found[len(found) - 1] = e }, LogOptions{})
+  lookup.PersonByEmail("john.q.public@gmail.com")
+  lookup.PersonByEmail("john.q.public@gmail.com")
+  SetExchangeLogger(nil, LogOptions{})
+  if len(found) != 2 || found[0].CacheHit || !found[1].CacheHit {
+    t.Errorf("Expected a miss and then a hit to be logged but found %v", found)
+    return
+  }
+  if found[1].Status != http.StatusOK || found[1].Bytes != 0 || strings.Index(found[1].Url, "/v3/person/email/j***@gmail.com") < 0 {
+    t.Errorf("Expected a masked 200 hit with no bytes but found %v", found[1])
+  }
+}
+
+func TestExchangeWriter(t *testing.T) {
+  b := bytes.NewBuffer(nil)
+  NewExchangeWriter(b)(&Exchange{Method:"GET", Url:"http://api.rapleaf.com:80/v3/person/email/j***@gmail.com", Endpoint:ENDPOINT_EMAIL, Key:"5eee3838", Status:200, Latency:12e6, Attempts:2, Bytes:10})
+  line := b.String()
+  for _, expected := range []string{"method=GET ", "url=\"http://api.rapleaf.com:80/v3/person/email/j***@gmail.com\"", "status=200 ", "latency=12ms ", "attempts=2 ", "cache=miss ", "bytes=10\n"} {
+    if strings.Index(line, expected) < 0 {
+      t.Errorf("Expected %q in log line %q", expected, line)
+    }
+  }
+}
@@ -0,0 +1,124 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "bytes"
+)
+
+func writeEscaped(b *bytes.Buffer, s string) {
+  for i := 0; i < len(s); i++ {
+    switch s[i] {
+    case '&':
+      b.WriteString("&amp;")
+    case '<':
+      b.WriteString("&lt;")
+    case '>':
+      b.WriteString("&gt;")
+    case '"':
+      b.WriteString("&quot;")
+    case '\'':
+      b.WriteString("&apos;")
+    default:
+      b.WriteByte(s[i])
+    }
+  }
+}
+
+func writeElement(b *bytes.Buffer, name, value string) {
+  if len(value) == 0 {
+    return
+  }
+  b.WriteString("<" + name + ">")
+  writeEscaped(b, value)
+  b.WriteString("</" + name + ">")
+}
+
+func writeAttribute(b *bytes.Buffer, name, value string) {
+  if len(value) == 0 {
+    return
+  }
+  b.WriteString(" " + name + "=\"")
+  writeEscaped(b, value)
+  b.WriteString("\"")
+}
+
+func writeMembership(b *bytes.Buffer, m *RapleafMemberSite) {
+  b.WriteString("<membership")
+  writeAttribute(b, "site", m.Site)
+  writeAttribute(b, "exists", m.Exists)
+  writeAttribute(b, "profile_url", m.ProfileUrl)
+  writeAttribute(b, "image_url", m.ImageUrl)
+  writeAttribute(b, "num_friends", m.NumFriends.String())
+  writeAttribute(b, "num_followers", m.NumFollowers.String())
+  writeAttribute(b, "num_followed", m.NumFollowed.String())
+  b.WriteString("/>")
+}
+
+// ToXML renders the person in the API's v3 schema.  Unknown values are
+// left out, and EmailAddress, which has no place in the schema, is
+// dropped.  Memberships marked Primary go under <primary> and the rest
+// under <supplemental>, so a parsed person round trips through
+// RapleafPersonFromString(p.ToXML()).
+func (p *RapleafPerson) ToXML() string {
+  b := bytes.NewBuffer(nil)
+  b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?><person")
+  writeAttribute(b, "id", p.Id)
+  b.WriteString("><basics>")
+  writeElement(b, "name", p.Name)
+  writeElement(b, "age", p.Age.String())
+  switch p.Gender {
+  case GENDER_MALE:
+    writeElement(b, "gender", "Male")
+  case GENDER_FEMALE:
+    writeElement(b, "gender", "Female")
+  default:
+    writeElement(b, "gender", string(p.Gender))
+  }
+  writeElement(b, "location", p.Location)
+  if len(p.Occupations) > 0 {
+    b.WriteString("<occupations>")
+    for _, o := range p.Occupations {
+      b.WriteString("<occupation")
+      writeAttribute(b, "job_title", o.JobTitle)
+      writeAttribute(b, "company", o.Company)
+      b.WriteString("/>")
+    }
+    b.WriteString("</occupations>")
+  }
+  if p.EarliestKnownActivity != nil {
+    writeElement(b, "earliest_known_activity", p.EarliestKnownActivity.Format(dateLayout))
+  }
+  if p.LatestKnownActivity != nil {
+    writeElement(b, "latest_known_activity", p.LatestKnownActivity.Format(dateLayout))
+  }
+  writeElement(b, "num_friends", p.NumFriends.String())
+  b.WriteString("</basics><memberships><primary>")
+  for _, m := range p.Memberships {
+    if m.Primary {
+      writeMembership(b, m)
+    }
+  }
+  b.WriteString("</primary><supplemental>")
+  for _, m := range p.Memberships {
+    if !m.Primary {
+      writeMembership(b, m)
+    }
+  }
+  b.WriteString("</supplemental></memberships></person>")
+  return b.String()
+}
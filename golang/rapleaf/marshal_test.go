@@ -0,0 +1,108 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "testing"
+)
+
+func TestToXMLRoundTrip(t *testing.T) {
+  for _, expected := range []*RapleafPerson{USER_EMPTY_PERSON, USER_WITH_PROFILE_PERSON} {
+    text := expected.ToXML()
+    u, err := RapleafPersonFromString(text)
+    if err != nil {
+      t.Errorf("Unable to parse marshalled person: %s\n%s", err.String(), text)
+      continue
+    }
+    if !expected.Equals(u) {
+      t.Errorf("Expected marshalled person to round trip but found:\n%s", text)
+    }
+  }
+}
+
+func TestToXMLInterleavedMemberships(t *testing.T) {
+  interleaved := *USER_WITH_PROFILE_PERSON
+  n := len(USER_WITH_PROFILE_PERSON.Memberships)
+  interleaved.Memberships = make([]*RapleafMemberSite, n)
+  for i, membership := range USER_WITH_PROFILE_PERSON.Memberships {
+    interleaved.Memberships[n - 1 - i] = membership
+  }
+  u, err := RapleafPersonFromString(interleaved.ToXML())
+  if err != nil {
+    t.Error("Unable to parse marshalled person: ", err.String())
+    return
+  }
+  if interleaved.Equals(u) {
+    t.Error("Expected supplemental memberships listed first to come back after the primary ones")
+  }
+  if !interleaved.EqualsWith(u, EqualsOptions{Unordered:true}) {
+    t.Error("Expected regrouped memberships to compare equal without order")
+  }
+  supplemental := false
+  for _, membership := range u.Memberships {
+    if membership.Primary && supplemental {
+      t.Errorf("Expected primary memberships first but found %s after a supplemental one", membership.Site)
+    }
+    supplemental = supplemental || !membership.Primary
+  }
+}
+
+func TestToXMLKeepsParsedGroups(t *testing.T) {
+  text := "<?xml version=\"1.0\" encoding=\"UTF-8\"?><person id=\"1\"><basics></basics><memberships><primary><membership site=\"orkut.com\" exists=\"true\"/></primary><supplemental><membership site=\"twitter.com\" exists=\"false\"/></supplemental></memberships></person>"
+  p, err := RapleafPersonFromString(text)
+  if err != nil {
+    t.Error("Unable to parse person: ", err.String())
+    return
+  }
+  if !p.Memberships[0].Primary || p.Memberships[1].Primary {
+    t.Errorf("Expected the groups memberships were parsed from but found %v", p.Memberships)
+  }
+  if found := p.ToXML(); found != text {
+    t.Errorf("Expected\n%s\nbut found\n%s", text, found)
+  }
+}
+
+func TestToXMLEscaping(t *testing.T) {
+  p := &RapleafPerson{
+    Id:"1",
+    Name:"Tom \"T\" O'Brien <tom>",
+    Occupations:[]*RapleafOccupation{&RapleafOccupation{Company:"Smith & Sons", JobTitle:"R&D"}},
+  }
+  expected := "<?xml version=\"1.0\" encoding=\"UTF-8\"?><person id=\"1\"><basics><name>Tom &quot;T&quot; O&apos;Brien &lt;tom&gt;</name><occupations><occupation job_title=\"R&amp;D\" company=\"Smith &amp; Sons\"/></occupations></basics><memberships><primary></primary><supplemental></supplemental></memberships></person>"
+  if text := p.ToXML(); text != expected {
+    t.Errorf("Expected\n%s\nbut found\n%s", expected, text)
+  }
+  u, err := RapleafPersonFromString(p.ToXML())
+  if err != nil || !p.Equals(u) {
+    t.Errorf("Expected escaped person to round trip but found %v (%v)", u, err)
+  }
+}
+
+func TestMockServerAddPerson(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.AddPerson("/v3/person/email/john.q.public@gmail.com", USER_WITH_PROFILE_PERSON)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  u := PersonByEmail(API_KEY, "john.q.public@gmail.com")
+  closeServerTestFiles(l)
+  expected := *USER_WITH_PROFILE_PERSON
+  expected.EmailAddress = "john.q.public@gmail.com"
+  testSamePerson(t, &expected, u)
+}
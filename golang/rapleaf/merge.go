@@ -0,0 +1,202 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "strconv"
+  "strings"
+  "time"
+)
+
+// A MergeConflict is a field on which two records held different non-empty
+// values.  Merge keeps the first record's value.
+type MergeConflict struct {
+  Field string
+  A string
+  B string
+}
+
+func (c *MergeConflict) String() string {
+  return c.Field + ": " + strconv.Quote(c.A) + " vs " + strconv.Quote(c.B)
+}
+
+type merger struct {
+  conflicts []*MergeConflict
+}
+
+func (m *merger) conflict(field, a, b string) {
+  if len(m.conflicts) == cap(m.conflicts) {
+    conflicts := make([]*MergeConflict, len(m.conflicts), 2 * len(m.conflicts) + 4)
+    copy(conflicts, m.conflicts)
+    m.conflicts = conflicts
+  }
+  m.conflicts = m.conflicts[0:len(m.conflicts) + 1]
+  m.conflicts[len(m.conflicts) - 1] = &MergeConflict{Field:field, A:a, B:b}
+}
+
+func (m *merger) mergeString(field, a, b string) string {
+  if len(a) == 0 {
+    return b
+  }
+  if len(b) > 0 && a != b {
+    m.conflict(field, a, b)
+  }
+  return a
+}
+
+func (m *merger) mergeInt(field string, a, b OptionalInt) OptionalInt {
+  if !a.Valid() {
+    return b
+  }
+  if b.Valid() && !a.Equals(b) {
+    m.conflict(field, a.String(), b.String())
+  }
+  return a
+}
+
+// existsRank orders membership states: a definite answer beats "tbd",
+// "unknown" or nothing.
+func existsRank(exists string) int {
+  switch exists {
+  case "true", "false":
+    return 2
+  case "tbd", "unknown":
+    return 1
+  }
+  return 0
+}
+
+func (m *merger) mergeExists(field, a, b string) string {
+  if existsRank(b) > existsRank(a) {
+    return b
+  }
+  if existsRank(a) == 2 && existsRank(b) == 2 && a != b {
+    m.conflict(field, a, b)
+  }
+  return a
+}
+
+func copyDate(t *time.Time) *time.Time {
+  if t == nil {
+    return nil
+  }
+  c := *t
+  return &c
+}
+
+func earlierDate(a, b *time.Time) *time.Time {
+  if a == nil || (b != nil && b.Seconds() < a.Seconds()) {
+    return copyDate(b)
+  }
+  return copyDate(a)
+}
+
+func laterDate(a, b *time.Time) *time.Time {
+  if a == nil || (b != nil && b.Seconds() > a.Seconds()) {
+    return copyDate(b)
+  }
+  return copyDate(a)
+}
+
+func occupationKey(o *RapleafOccupation) string {
+  return strings.ToLower(strings.TrimSpace(o.Company)) + "\n" + strings.ToLower(strings.TrimSpace(o.JobTitle))
+}
+
+func mergeOccupations(a, b []*RapleafOccupation) []*RapleafOccupation {
+  v := make([]*RapleafOccupation, 0, len(a) + len(b))
+  seen := make(map[string]bool)
+  for _, list := range [][]*RapleafOccupation{a, b} {
+    for _, o := range list {
+      if o == nil || seen[occupationKey(o)] {
+        continue
+      }
+      seen[occupationKey(o)] = true
+      c := *o
+      v = v[0:len(v) + 1]
+      v[len(v) - 1] = &c
+    }
+  }
+  return v
+}
+
+func (m *merger) mergeMemberSite(a, b *RapleafMemberSite) *RapleafMemberSite {
+  field := "Memberships[" + a.Site + "]."
+  return &RapleafMemberSite{
+    Site:a.Site,
+    ProfileUrl:m.mergeString(field + "ProfileUrl", a.ProfileUrl, b.ProfileUrl),
+    ImageUrl:m.mergeString(field + "ImageUrl", a.ImageUrl, b.ImageUrl),
+    NumFriends:m.mergeInt(field + "NumFriends", a.NumFriends, b.NumFriends),
+    NumFollowers:m.mergeInt(field + "NumFollowers", a.NumFollowers, b.NumFollowers),
+    NumFollowed:m.mergeInt(field + "NumFollowed", a.NumFollowed, b.NumFollowed),
+    Exists:m.mergeExists(field + "Exists", a.Exists, b.Exists),
+    Primary:a.Primary || b.Primary,
+  }
+}
+
+func (m *merger) mergeMemberships(a, b []*RapleafMemberSite) []*RapleafMemberSite {
+  v := make([]*RapleafMemberSite, 0, len(a) + len(b))
+  index := make(map[string]int)
+  for _, list := range [][]*RapleafMemberSite{a, b} {
+    for _, s := range list {
+      if s == nil {
+        continue
+      }
+      if i, ok := index[s.Site]; ok {
+        v[i] = m.mergeMemberSite(v[i], s)
+        continue
+      }
+      index[s.Site] = len(v)
+      c := *s
+      v = v[0:len(v) + 1]
+      v[len(v) - 1] = &c
+    }
+  }
+  return v
+}
+
+// Merge combines two records of the same person, e.g. found by email and
+// by a site profile.  Occupations are unioned by company and title and
+// memberships by site; the earliest EarliestKnownActivity and the latest
+// LatestKnownActivity are kept; otherwise a non-empty value wins over an
+// empty one.  Where both hold different values a's is kept and the
+// disagreement is reported.  Neither argument is modified.
+func Merge(a, b *RapleafPerson) (*RapleafPerson, []*MergeConflict) {
+  if a == nil {
+    a, b = b, nil
+  }
+  if a == nil {
+    return nil, nil
+  }
+  if b == nil {
+    b = &RapleafPerson{}
+  }
+  m := &merger{conflicts:make([]*MergeConflict, 0, 4)}
+  p := &RapleafPerson{
+    Id:m.mergeString("Id", a.Id, b.Id),
+    Name:m.mergeString("Name", a.Name, b.Name),
+    Gender:Gender(m.mergeString("Gender", string(a.Gender), string(b.Gender))),
+    Location:m.mergeString("Location", a.Location, b.Location),
+    NumFriends:m.mergeInt("NumFriends", a.NumFriends, b.NumFriends),
+    Age:m.mergeInt("Age", a.Age, b.Age),
+    EarliestKnownActivity:earlierDate(a.EarliestKnownActivity, b.EarliestKnownActivity),
+    LatestKnownActivity:laterDate(a.LatestKnownActivity, b.LatestKnownActivity),
+    Occupations:mergeOccupations(a.Occupations, b.Occupations),
+    Memberships:m.mergeMemberships(a.Memberships, b.Memberships),
+    EmailAddress:m.mergeString("EmailAddress", a.EmailAddress, b.EmailAddress),
+  }
+  return p, m.conflicts
+}
@@ -0,0 +1,99 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "testing"
+  "time"
+)
+
+func TestMerge(t *testing.T) {
+  a := *USER_WITH_PROFILE_PERSON
+  a.EmailAddress = "john.q.public@gmail.com"
+  b := &RapleafPerson{
+    Id:"97fc425100000000",
+    Name:"John Q Public",
+    Age:Known(29),
+    EarliestKnownActivity:&time.Time{Year:1999, Month:1, Day:1},
+    LatestKnownActivity:&time.Time{Year:2009, Month:1, Day:1},
+    Occupations:[]*RapleafOccupation{
+      &RapleafOccupation{Company:"apple", JobTitle:"Software Developer"},
+      &RapleafOccupation{Company:"Acme", JobTitle:"CTO"},
+    },
+    Memberships:[]*RapleafMemberSite{
+      &RapleafMemberSite{Site:"twitter.com", ProfileUrl:"http://twitter.com/johnqpublic", NumFollowers:Known(20), Exists:"true"},
+      &RapleafMemberSite{Site:"facebook.com", ProfileUrl:"http://www.facebook.com/johnqpublic", Exists:"true"},
+      &RapleafMemberSite{Site:"github.com", ProfileUrl:"http://github.com/johnqpublic", Exists:"true"},
+    },
+  }
+  p, conflicts := Merge(&a, b)
+  if p.Age.Int() != 28 || p.Gender != "male" || p.EmailAddress != "john.q.public@gmail.com" {
+    t.Errorf("Expected first record's age, gender and email but found %s, %s and %s", p.Age.String(), p.Gender, p.EmailAddress)
+  }
+  if p.EarliestKnownActivity.Year != 1999 || p.LatestKnownActivity.Year != 2010 {
+    t.Errorf("Expected earliest activity in 1999 and latest in 2010 but found %v and %v", p.EarliestKnownActivity, p.LatestKnownActivity)
+  }
+  if len(p.Occupations) != 4 || p.Occupations[3].Company != "Acme" {
+    t.Errorf("Expected 4 occupations ending with Acme but found %v", p.Occupations)
+  }
+  if len(p.Memberships) != len(a.Memberships) + 1 {
+    t.Errorf("Expected %d memberships but found %d", len(a.Memberships) + 1, len(p.Memberships))
+    return
+  }
+  for _, m := range p.Memberships {
+    switch m.Site {
+    case "facebook.com":
+      if m.ProfileUrl != "http://www.facebook.com/johnqpublic" {
+        t.Errorf("Expected facebook profile url from second record but found %s", m.ProfileUrl)
+      }
+    case "twitter.com":
+      if m.NumFollowers.Int() != 14 || m.NumFollowed.Int() != 4 {
+        t.Errorf("Expected twitter counts from first record but found %v", m)
+      }
+    }
+  }
+  expected := []string{
+    "Age: \"28\" vs \"29\"",
+    "Memberships[twitter.com].NumFollowers: \"14\" vs \"20\"",
+  }
+  if len(conflicts) != len(expected) {
+    t.Errorf("Expected %d conflicts but found %v", len(expected), conflicts)
+    return
+  }
+  for i, c := range conflicts {
+    if c.String() != expected[i] {
+      t.Errorf("Expected conflict %s but found %s", expected[i], c.String())
+    }
+  }
+  testSamePerson(t, USER_WITH_PROFILE_PERSON, &RapleafPerson{
+    Id:a.Id, Name:a.Name, Gender:a.Gender, Location:a.Location, NumFriends:a.NumFriends, Age:a.Age,
+    EarliestKnownActivity:a.EarliestKnownActivity, LatestKnownActivity:a.LatestKnownActivity,
+    Occupations:a.Occupations, Memberships:a.Memberships,
+  })
+}
+
+func TestMergeNil(t *testing.T) {
+  p, conflicts := Merge(nil, USER_EMPTY_PERSON)
+  testSamePerson(t, USER_EMPTY_PERSON, p)
+  if len(conflicts) != 0 {
+    t.Errorf("Expected no conflicts but found %v", conflicts)
+  }
+  if p == USER_EMPTY_PERSON || p.Memberships[0] == USER_EMPTY_PERSON.Memberships[0] {
+    t.Error("Expected merge to copy rather than share the record")
+  }
+}
@@ -0,0 +1,174 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "http"
+  "io/ioutil"
+  "os"
+  "path"
+  "strconv"
+  "sync"
+)
+
+// MockServer is an http.Handler that answers person and graph lookups from
+// canned responses, for tests and for local development against something
+// shaped like api.rapleaf.com.
+type MockServer struct {
+  // ApiKey, when set, must match the Authorization header or the request
+  // fails with 401.
+  ApiKey string
+  // Quota is the number of authorized calls answered before every further
+  // call fails with 403.  Zero means no limit.
+  Quota int
+  // Pending is the number of calls for each path answered with 202 before
+  // the fixture is returned, like the API does while it searches.
+  Pending int
+  fixtures map[string]*mockFixture
+  calls int
+  pending map[string]int
+  lock sync.Mutex
+}
+
+type mockFixture struct {
+  contentType string
+  body []byte
+}
+
+var (
+  mockContentTypes = map[string]string {
+    ".xml" : "application/xml;charset=UTF-8",
+    ".json" : "application/json;charset=UTF-8",
+    ".txt" : "text/plain;charset=UTF-8",
+  }
+)
+
+func NewMockServer(api_key string) *MockServer {
+  return &MockServer{
+    ApiKey:api_key,
+    fixtures:make(map[string]*mockFixture),
+    pending:make(map[string]int),
+  }
+}
+
+// AddFixture serves body for requests to urlPath, e.g.
+// "/v3/person/email/john.q.public@gmail.com".  Graph fixtures may include the
+// query string, e.g. "/v2/graph/john.q.public@gmail.com?n=2", to answer it
+// differently from the bare path.
+func (p *MockServer) AddFixture(urlPath, contentType, body string) {
+  p.lock.Lock()
+  p.fixtures[urlPath] = &mockFixture{contentType:contentType, body:[]byte(body)}
+  p.lock.Unlock()
+}
+
+// AddPerson serves person as the XML the API would return for urlPath.
+func (p *MockServer) AddPerson(urlPath string, person *RapleafPerson) {
+  p.AddFixture(urlPath, mockContentTypes[".xml"], person.ToXML())
+}
+
+// LoadFixtures adds every .xml, .json and .txt file below dir, mapping
+// dir/v3/person/email/john.q.public@gmail.com.xml to the URL path
+// /v3/person/email/john.q.public@gmail.com.
+func (p *MockServer) LoadFixtures(dir string) os.Error {
+  return p.loadFixtures(dir, "")
+}
+
+func (p *MockServer) loadFixtures(dir, urlPath string) os.Error {
+  infos, err := ioutil.ReadDir(dir)
+  if err != nil {
+    return err
+  }
+  for _, info := range infos {
+    filename := path.Join(dir, info.Name)
+    if info.IsDirectory() {
+      if err = p.loadFixtures(filename, urlPath + "/" + info.Name); err != nil {
+        return err
+      }
+      continue
+    }
+    ext := path.Ext(info.Name)
+    contentType, ok := mockContentTypes[ext]
+    if !ok {
+      continue
+    }
+    body, err := ioutil.ReadFile(filename)
+    if err != nil {
+      return err
+    }
+    p.AddFixture(urlPath + "/" + info.Name[0:len(info.Name) - len(ext)], contentType, string(body))
+  }
+  return nil
+}
+
+// Calls returns the number of authorized calls served so far.
+func (p *MockServer) Calls() int {
+  p.lock.Lock()
+  defer p.lock.Unlock()
+  return p.calls
+}
+
+func writeMockError(conn *http.Conn, code int) {
+  text := []byte(ERROR_CODES[code])
+  conn.SetHeader("Content-Type", "text/html;charset=ISO-8859-1")
+  conn.SetHeader("Cache-Control", "must-revalidate,no-cache,no-store")
+  conn.SetHeader("Content-Length", strconv.Itoa(len(text)))
+  conn.WriteHeader(code)
+  conn.Write(text)
+  conn.Flush()
+}
+
+func (p *MockServer) ServeHTTP(conn *http.Conn, req *http.Request) {
+  req.Close = true
+  if len(p.ApiKey) > 0 {
+    if api_key, ok := req.Header["Authorization"]; !ok || api_key != p.ApiKey {
+      writeMockError(conn, http.StatusUnauthorized)
+      return
+    }
+  }
+  p.lock.Lock()
+  p.calls++
+  if p.Quota > 0 && p.calls > p.Quota {
+    p.lock.Unlock()
+    writeMockError(conn, http.StatusForbidden)
+    return
+  }
+  fixture, ok := p.fixtures[req.URL.Path + "?" + req.URL.RawQuery]
+  if !ok {
+    fixture, ok = p.fixtures[req.URL.Path]
+  }
+  searching := false
+  if ok && p.pending[req.URL.Path] < p.Pending {
+    p.pending[req.URL.Path]++
+    searching = true
+  }
+  p.lock.Unlock()
+  if !ok {
+    writeMockError(conn, http.StatusNotFound)
+    return
+  }
+  if searching {
+    writeMockError(conn, http.StatusAccepted)
+    return
+  }
+  conn.SetHeader("Content-Type", fixture.contentType)
+  conn.SetHeader("Transfer-Encoding", "chunked")
+  // for some reason, api.rapleaf.com does not send Content-Length
+  // when sending stored data
+  conn.WriteHeader(http.StatusOK)
+  conn.Write(fixture.body)
+  conn.Flush()
+}
@@ -0,0 +1,80 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "http"
+  "testing"
+)
+
+func TestMockServerUnauthorized(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  code, text := PersonXmlByEmail("not the key", "john.q.public@gmail.com")
+  closeServerTestFiles(l)
+  if code != http.StatusUnauthorized {
+    t.Error("Expected status code 401 but received ", code, " with message: ", text)
+  }
+  if server.Calls() != 0 {
+    t.Error("Expected unauthorized call not to be counted but found ", server.Calls())
+  }
+}
+
+func TestMockServerQuota(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.Quota = 2
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  expected := []int{http.StatusOK, http.StatusNotFound, http.StatusForbidden}
+  found := make([]int, len(expected))
+  found[0], _ = PersonXmlByEmail(API_KEY, "john.q.public@gmail.com")
+  found[1], _ = PersonXmlByEmail(API_KEY, "nobody@gmail.com")
+  found[2], _ = PersonXmlByEmail(API_KEY, "john.q.public@gmail.com")
+  closeServerTestFiles(l)
+  for i, code := range expected {
+    if found[i] != code {
+      t.Errorf("Expected status code %d but received %d for call %d", code, found[i], i + 1)
+    }
+  }
+}
+
+func TestMockServerPending(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.Pending = 1
+  server.AddFixture("/v3/person/web/linkedin/johnqpublic", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  code1, _ := PersonXmlBySite(API_KEY, "linkedin", "johnqpublic")
+  code2, _ := PersonXmlBySite(API_KEY, "linkedin", "johnqpublic")
+  closeServerTestFiles(l)
+  if code1 != http.StatusAccepted {
+    t.Error("Expected status code 202 on first call but received ", code1)
+  }
+  if code2 != http.StatusOK {
+    t.Error("Expected status code 200 on second call but received ", code2)
+  }
+}
@@ -0,0 +1,83 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "os"
+  "strconv"
+)
+
+// An OptionalInt is a count or age the API may leave out.  The zero value
+// is unknown, which is not the same as a known zero.  It encodes to JSON
+// as a number, or null when unknown.
+type OptionalInt struct {
+  value int
+  valid bool
+}
+
+// Known returns the OptionalInt holding n.
+func Known(n int) OptionalInt {
+  return OptionalInt{value:n, valid:true}
+}
+
+func (o OptionalInt) Valid() bool {
+  return o.valid
+}
+
+// Int returns the value, or 0 when it is unknown.
+func (o OptionalInt) Int() int {
+  return o.value
+}
+
+func (o OptionalInt) Equals(other OptionalInt) bool {
+  return o.valid == other.valid && o.value == other.value
+}
+
+// String returns the value in decimal, or "" when it is unknown.
+func (o OptionalInt) String() string {
+  if !o.valid {
+    return ""
+  }
+  return strconv.Itoa(o.value)
+}
+
+func (o OptionalInt) goString() string {
+  if !o.valid {
+    return "rapleaf.OptionalInt{}"
+  }
+  return "rapleaf.Known(" + strconv.Itoa(o.value) + ")"
+}
+
+func (o OptionalInt) MarshalJSON() ([]byte, os.Error) {
+  if !o.valid {
+    return []byte("null"), nil
+  }
+  return []byte(strconv.Itoa(o.value)), nil
+}
+
+func (o *OptionalInt) UnmarshalJSON(data []byte) os.Error {
+  if string(data) == "null" {
+    *o = OptionalInt{}
+    return nil
+  }
+  n, err := strconv.Atoi(string(data))
+  if err != nil {
+    return err
+  }
+  *o = Known(n)
+  return nil
+}
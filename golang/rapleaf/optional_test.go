@@ -0,0 +1,67 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "json"
+  "testing"
+)
+
+func TestOptionalInt(t *testing.T) {
+  var unknown OptionalInt
+  if unknown.Valid() || unknown.Equals(Known(0)) || unknown.String() != "" {
+    t.Errorf("Expected the zero OptionalInt to be unknown but found %q", unknown.String())
+  }
+  if !Known(0).Valid() || Known(0).String() != "0" || !Known(7).Equals(Known(7)) {
+    t.Error("Expected Known(0) to be a known zero")
+  }
+  type counts struct {
+    A OptionalInt "a"
+    B OptionalInt "b"
+  }
+  buf, err := json.Marshal(&counts{A:Known(0)})
+  if err != nil {
+    t.Error("Unable to marshal optional values: ", err.String())
+    return
+  }
+  if string(buf) != "{\"a\":0,\"b\":null}" {
+    t.Errorf("Expected a known zero and a null but found %s", buf)
+  }
+  decoded := &counts{B:Known(3)}
+  if err = json.Unmarshal(buf, decoded); err != nil {
+    t.Error("Unable to unmarshal optional values: ", err.String())
+    return
+  }
+  if !decoded.A.Equals(Known(0)) || decoded.B.Valid() {
+    t.Errorf("Expected a known zero and an unknown but found %q and %q", decoded.A.String(), decoded.B.String())
+  }
+}
+
+func TestUnknownAge(t *testing.T) {
+  u, err := RapleafPersonFromString(USER_EMPTY_XML)
+  if err != nil {
+    t.Error("Unable to parse person: ", err.String())
+    return
+  }
+  if u.Age.Valid() || u.LatestKnownActivity != nil {
+    t.Errorf("Expected absent age and activity to be unknown but found %q and %v", u.Age.String(), u.LatestKnownActivity)
+  }
+  if !u.NumFriends.Equals(Known(0)) {
+    t.Errorf("Expected a reported zero friend count to be known but found %q", u.NumFriends.String())
+  }
+}
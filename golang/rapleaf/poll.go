@@ -0,0 +1,44 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+import (
+  "sync"
+)
+
+var (
+  pollCount int
+  pollInterval int64
+  pollLock sync.RWMutex
+)
+
+// SetPolling makes lookups answered with 202, meaning the API is still
+// searching, wait interval nanoseconds and ask again, up to polls times.
+// Zero polls, the default, returns the 202 to the caller.
+func SetPolling(polls int, interval int64) {
+  pollLock.Lock()
+  pollCount = polls
+  pollInterval = interval
+  pollLock.Unlock()
+}
+
+func currentPolling() (int, int64) {
+  pollLock.RLock()
+  defer pollLock.RUnlock()
+  return pollCount, pollInterval
+}
@@ -20,6 +20,7 @@ import (
   "bytes"
   "fmt"
   "http"
+  "io"
   "io/ioutil"
   "net"
   "os"
@@ -31,6 +32,8 @@ import (
 
 const (
   dateLayout = "2006-01-02"
+  graphRapleafIds = 1
+  graphEmailAddresses = 2
 )
 
 var (
@@ -52,30 +55,6 @@ func OverrideRapleafHostPort(host, port string) {
   rapleaf_port = port
 }
 
-func personUrl(a ...string) string {
-  arr := []string{"http://", rapleaf_host, ":", rapleaf_port, "/v3/person/"}
-  v := make([]string, len(a) + len(arr))
-  for i, e := range arr {
-    v[i] = e
-  }
-  for i, e := range a {
-    v[i + len(arr)] = e
-  }
-  return strings.Join(v, "");
-}
-
-func graphUrl(a ...string) string {
-  arr := []string{"http://", rapleaf_host, ":", rapleaf_port, "/v2/graph/"}
-  v := make([]string, len(a) + len(arr))
-  for i, e := range arr {
-    v[i] = e
-  }
-  for i, e := range a {
-    v[i + len(arr)] = e
-  }
-  return strings.Join(v, "");
-}
-
 type rapleafMemberSite struct {
   XMLName xml.Name "membership"
   Site string "attr"
@@ -119,8 +98,8 @@ type rapleafBasics struct {
   Name string
   Gender string
   Location string
-  Num_friends int
-  Age int
+  Num_friends string
+  Age string
   Earliest_known_activity string
   Latest_known_activity string
   Occupations []rapleafOccupations
@@ -137,10 +116,12 @@ type RapleafMemberSite struct {
   Site string
   ProfileUrl string
   ImageUrl string
-  NumFriends int
-  NumFollowers int
-  NumFollowed int
+  NumFriends OptionalInt
+  NumFollowers OptionalInt
+  NumFollowed OptionalInt
   Exists string
+  // Primary is set for memberships listed under <primary>
+  Primary bool
 }
 
 type RapleafOccupation struct {
@@ -151,10 +132,11 @@ type RapleafOccupation struct {
 type RapleafPerson struct {
   Id string
   Name string
-  Gender string
+  Gender Gender
   Location string
-  NumFriends int
-  Age int
+  NumFriends OptionalInt
+  Age OptionalInt
+  // nil when unknown
   EarliestKnownActivity *time.Time
   LatestKnownActivity *time.Time
   Occupations []*RapleafOccupation
@@ -162,10 +144,106 @@ type RapleafPerson struct {
   EmailAddress string
 }
 
-func (p* rapleafMemberSite) toPublicStruct() *RapleafMemberSite {
-  friends, _ := strconv.Atoi(p.Num_friends)
-  followers, _ := strconv.Atoi(p.Num_followers)
-  followed, _ := strconv.Atoi(p.Num_followed)
+// A ParseWarning records a value in a response that could not be coerced
+// to its field's type; the field is left unknown.  Field is named as in Diff
+// paths.
+type ParseWarning struct {
+  Field string
+  Value string
+  Err os.Error
+  // Note marks a value that was kept as it came rather than dropped, such
+  // as an unrecognized gender; notes do not fail a strict parse
+  Note bool
+}
+
+func (w *ParseWarning) String() string {
+  return w.Field + ": " + strconv.Quote(w.Value) + ": " + w.Err.String()
+}
+
+// ParseWarnings is returned as the error of a strict parse.
+type ParseWarnings []*ParseWarning
+
+func (w ParseWarnings) String() string {
+  v := make([]string, len(w))
+  for i, warning := range w {
+    v[i] = warning.String()
+  }
+  return "rapleaf: invalid values in response: " + strings.Join(v, "; ")
+}
+
+type ParseOptions struct {
+  // Strict makes any ParseWarning other than a Note fail the parse
+  Strict bool
+}
+
+// converter coerces response strings, collecting what it cannot coerce.
+// Empty values are absent rather than invalid.
+type converter struct {
+  warnings ParseWarnings
+}
+
+func (c *converter) warn(field, value string, err os.Error) {
+  if len(c.warnings) == cap(c.warnings) {
+    warnings := make(ParseWarnings, len(c.warnings), 2 * len(c.warnings) + 4)
+    copy(warnings, c.warnings)
+    c.warnings = warnings
+  }
+  c.warnings = c.warnings[0:len(c.warnings) + 1]
+  c.warnings[len(c.warnings) - 1] = &ParseWarning{Field:field, Value:value, Err:err}
+}
+
+func (c *converter) note(field, value string, err os.Error) {
+  c.warn(field, value, err)
+  c.warnings[len(c.warnings) - 1].Note = true
+}
+
+// failed reports whether any warning is more than a note.
+func (c *converter) failed() bool {
+  for _, w := range c.warnings {
+    if !w.Note {
+      return true
+    }
+  }
+  return false
+}
+
+func (c *converter) atoi(field, value string) OptionalInt {
+  if len(value) == 0 {
+    return OptionalInt{}
+  }
+  n, err := strconv.Atoi(strings.TrimSpace(value))
+  if err != nil {
+    c.warn(field, value, err)
+    return OptionalInt{}
+  }
+  return Known(n)
+}
+
+func (c *converter) gender(field, value string) Gender {
+  g, err := ParseGender(value)
+  if err != nil {
+    c.note(field, value, err)
+  }
+  return g
+}
+
+func (c *converter) date(field, value string) *time.Time {
+  if len(value) == 0 {
+    return nil
+  }
+  t, err := time.Parse(dateLayout, value)
+  if err != nil {
+    c.warn(field, value, err)
+    return nil
+  }
+  return t
+}
+
+func (p* rapleafMemberSite) toPublicStruct(c *converter, primary bool) *RapleafMemberSite {
+  path := "Memberships[" + p.Site + "]."
+  friends := c.atoi(path + "NumFriends", p.Num_friends)
+  followers := c.atoi(path + "NumFollowers", p.Num_followers)
+  followed := c.atoi(path + "NumFollowed", p.Num_followed)
   return &RapleafMemberSite{
     Site:p.Site,
     ProfileUrl:p.Profile_url,
@@ -174,6 +252,7 @@ func (p* rapleafMemberSite) toPublicStruct() *RapleafMemberSite {
     NumFollowers:followers,
     NumFollowed:followed,
     Exists:p.Exists,
+    Primary:primary,
   }
 }
 
@@ -184,9 +263,9 @@ func (p* rapleafOccupation) toPublicStruct() *RapleafOccupation {
   }
 }
 
-func (p* rapleafPerson) toPublicStruct() *RapleafPerson {
-  earliest_known_activity, _ := time.Parse(dateLayout, p.Basics.Earliest_known_activity)
-  latest_known_activity, _ := time.Parse(dateLayout, p.Basics.Latest_known_activity)
+func (p* rapleafPerson) toPublicStruct(c *converter) *RapleafPerson {
+  earliest_known_activity := c.date("EarliestKnownActivity", p.Basics.Earliest_known_activity)
+  latest_known_activity := c.date("LatestKnownActivity", p.Basics.Latest_known_activity)
   num_occupations := 0
   if len(p.Basics.Occupations) > 0 {
     num_occupations = len(p.Basics.Occupations[0].Occupation)
@@ -200,20 +279,20 @@ func (p* rapleafPerson) toPublicStruct() *RapleafPerson {
   }
   i := 0
   for _, membership := range p.Memberships.Primary.Membership {
-    memberships[i] = membership.toPublicStruct()
+    memberships[i] = membership.toPublicStruct(c, true)
     i++
   }
   for _, membership := range p.Memberships.Supplemental.Membership {
-    memberships[i] = membership.toPublicStruct()
+    memberships[i] = membership.toPublicStruct(c, false)
     i++
   }
   return &RapleafPerson{
     Id:p.Id,
     Name:p.Basics.Name,
-    Gender:strings.ToLower(p.Basics.Gender),
+    Gender:c.gender("Gender", p.Basics.Gender),
     Location:p.Basics.Location,
-    NumFriends:p.Basics.Num_friends,
-    Age:p.Basics.Age,
+    NumFriends:c.atoi("NumFriends", p.Basics.Num_friends),
+    Age:c.atoi("Age", p.Basics.Age),
     EarliestKnownActivity:earliest_known_activity,
     LatestKnownActivity:latest_known_activity,
     Occupations:occupations,
@@ -226,21 +305,30 @@ func (p *RapleafMemberSite) String() string {
     "Site:", strconv.Quote(p.Site), ", ",
     "ProfileUrl:", strconv.Quote(p.ProfileUrl), ", ",
     "ImageUrl:", strconv.Quote(p.ImageUrl), ", ",
-    "NumFriends:", strconv.Itoa(p.NumFriends), ", ",
-    "NumFollowers:", strconv.Itoa(p.NumFollowers), ", ",
-    "NumFollowed:", strconv.Itoa(p.NumFollowed), ", ",
-    "Exists:", strconv.Quote(p.Exists), "}",
+    "NumFriends:", p.NumFriends.goString(), ", ",
+    "NumFollowers:", p.NumFollowers.goString(), ", ",
+    "NumFollowed:", p.NumFollowed.goString(), ", ",
+    "Exists:", strconv.Quote(p.Exists), ", ",
+    "Primary:", strconv.Btoa(p.Primary), "}",
   }
   return strings.Join(arr, "")
 }
 
 func (p *RapleafMemberSite) Equals(other *RapleafMemberSite) bool {
-  return other != nil && p.ProfileUrl == other.ProfileUrl && 
-    p.ImageUrl == other.ImageUrl && 
-    p.NumFriends == other.NumFriends &&
-    p.NumFollowers == other.NumFollowers &&
-    p.NumFollowed == other.NumFollowed &&
-    p.Exists == other.Exists
+  return p.EqualsWith(other, EqualsOptions{})
+}
+
+func (p *RapleafMemberSite) EqualsWith(other *RapleafMemberSite, options EqualsOptions) bool {
+  if other == nil || p.Site != other.Site ||
+      p.ProfileUrl != other.ProfileUrl ||
+      p.ImageUrl != other.ImageUrl ||
+      p.Exists != other.Exists ||
+      p.Primary != other.Primary {
+    return false
+  }
+  return options.IgnoreCounters || (p.NumFriends.Equals(other.NumFriends) &&
+    p.NumFollowers.Equals(other.NumFollowers) &&
+    p.NumFollowed.Equals(other.NumFollowed))
 }
 
 func (p *RapleafOccupation) String() string {
@@ -259,12 +347,12 @@ func (p *RapleafOccupation) Equals(other *RapleafOccupation) bool {
 func (p *RapleafPerson) String() string {
   var earliest_known_activity_str string
   var latest_known_activity_str string
-  if p.EarliestKnownActivity != nil && p.EarliestKnownActivity.Year > 1000 {
+  if p.EarliestKnownActivity != nil {
     earliest_known_activity_str = fmt.Sprintf("&time.Time{Year:%d, Month:%d, Day:%d}", p.EarliestKnownActivity.Year, p.EarliestKnownActivity.Month, p.EarliestKnownActivity.Day)
   } else {
     earliest_known_activity_str = "nil"
   }
-  if p.LatestKnownActivity != nil && p.LatestKnownActivity.Year > 1000 {
+  if p.LatestKnownActivity != nil {
     latest_known_activity_str = fmt.Sprintf("&time.Time{Year:%d, Month:%d, Day:%d}", p.LatestKnownActivity.Year, p.LatestKnownActivity.Month, p.LatestKnownActivity.Day)
   } else {
     latest_known_activity_str = "nil"
@@ -280,10 +368,10 @@ func (p *RapleafPerson) String() string {
   arr := []string{"rapleaf.RapleafPerson{",
     "Id:", strconv.Quote(p.Id), ", ",
     "Name:", strconv.Quote(p.Name), ", ",
-    "Gender:", strconv.Quote(p.Gender), ", ",
+    "Gender:", strconv.Quote(string(p.Gender)), ", ",
     "Location:", strconv.Quote(p.Location), ", ",
-    "NumFriends:", strconv.Itoa(p.NumFriends), ", ",
-    "Age:", strconv.Itoa(p.Age), ", ",
+    "NumFriends:", p.NumFriends.goString(), ", ",
+    "Age:", p.Age.goString(), ", ",
     "EarliestKnownActivity:", earliest_known_activity_str, ", ",
     "LatestKnownActivity:", latest_known_activity_str, ", ",
     "EmailAddress:", strconv.Quote(p.EmailAddress), ", ",
@@ -293,7 +381,21 @@ func (p *RapleafPerson) String() string {
   return strings.Join(arr, "")
 }
 
+// EqualsOptions loosens RapleafPerson.EqualsWith.  Unordered compares
+// occupations as a set keyed by company and title, ignoring case as Merge
+// does, and memberships as a set keyed by site, since the API does not
+// promise an order.  IgnoreCounters skips the friend and follower counts,
+// which change all the time.
+type EqualsOptions struct {
+  Unordered bool
+  IgnoreCounters bool
+}
+
 func (p *RapleafPerson) Equals(other *RapleafPerson) bool {
+  return p.EqualsWith(other, EqualsOptions{})
+}
+
+func (p *RapleafPerson) EqualsWith(other *RapleafPerson, options EqualsOptions) bool {
   if other == nil {
     return false
   }
@@ -301,8 +403,8 @@ func (p *RapleafPerson) Equals(other *RapleafPerson) bool {
       p.Name != other.Name ||
       p.Gender != other.Gender ||
       p.Location != other.Location ||
-      p.NumFriends != other.NumFriends ||
-      p.Age != other.Age ||
+      (!options.IgnoreCounters && !p.NumFriends.Equals(other.NumFriends)) ||
+      !p.Age.Equals(other.Age) ||
       p.EmailAddress != other.EmailAddress ||
       len(p.Occupations) != len(other.Occupations) ||
       len(p.Memberships) != len(other.Memberships) {
@@ -324,13 +426,58 @@ func (p *RapleafPerson) Equals(other *RapleafPerson) bool {
       return false
     }
   }
+  if options.Unordered {
+    return sameOccupations(p.Occupations, other.Occupations) &&
+      sameMemberships(p.Memberships, other.Memberships, options)
+  }
   for i, occupation := range p.Occupations {
     if !occupation.Equals(other.Occupations[i]) {
       return false
     }
   }
   for i, membership := range p.Memberships {
-    if !membership.Equals(other.Memberships[i]) {
+    if !membership.EqualsWith(other.Memberships[i], options) {
+      return false
+    }
+  }
+  return true
+}
+
+func sameOccupations(a, b []*RapleafOccupation) bool {
+  counts := make(map[string]int)
+  for _, occupation := range a {
+    counts[occupationKey(occupation)]++
+  }
+  for _, occupation := range b {
+    key := occupationKey(occupation)
+    if counts[key] == 0 {
+      return false
+    }
+    counts[key]--
+  }
+  return true
+}
+
+func sameMemberships(a, b []*RapleafMemberSite, options EqualsOptions) bool {
+  a_sites := make(map[string]*RapleafMemberSite)
+  b_sites := make(map[string]*RapleafMemberSite)
+  for _, membership := range a {
+    a_sites[membership.Site] = membership
+  }
+  for _, membership := range b {
+    b_sites[membership.Site] = membership
+  }
+  if len(a_sites) != len(a) || len(b_sites) != len(b) {
+    // a site listed twice cannot be keyed; compare in order instead
+    for i, membership := range a {
+      if !membership.EqualsWith(b[i], options) {
+        return false
+      }
+    }
+    return true
+  }
+  for site, membership := range a_sites {
+    if !membership.EqualsWith(b_sites[site], options) {
       return false
     }
   }
@@ -338,19 +485,60 @@ func (p *RapleafPerson) Equals(other *RapleafPerson) bool {
 }
 
 func RapleafPersonFromString(value string) (*RapleafPerson, os.Error) {
-  if(len(value) == 0) { return nil, nil; }
+  u, _, err := RapleafPersonFromStringWith(value, ParseOptions{})
+  return u, err
+}
+
+// RapleafPersonFromStringWith also returns the values that could not be
+// coerced.  In strict mode they are returned as a ParseWarnings error
+// instead of a person.
+func RapleafPersonFromStringWith(value string, options ParseOptions) (*RapleafPerson, []*ParseWarning, os.Error) {
+  if(len(value) == 0) { return nil, nil, nil; }
   b := bytes.NewBufferString(value)
   p := &rapleafPerson{}
   if err := xml.Unmarshal(b, p); err != nil {
-    return nil, err
+    return nil, nil, err
   }
-  return p.toPublicStruct(), nil
+  return p.convert(options)
 }
 
-func retrieve(api_key, url string) (code int, text string) {
+func (p *rapleafPerson) convert(options ParseOptions) (*RapleafPerson, []*ParseWarning, os.Error) {
+  c := &converter{}
+  u := p.toPublicStruct(c)
+  if options.Strict && c.failed() {
+    return nil, c.warnings, c.warnings
+  }
+  return u, c.warnings, nil
+}
+
+// A Response is what came back for a lookup.  Status and Body are made
+// up locally, and Synthesized set, when no answer came from the API: the
+// connection failed, the circuit breaker was open or no key was left.
+// Elapsed is in nanoseconds and Attempts counts the keys tried, so both
+// include retries through a KeyPool.  Err holds a *ContentError when the
+// body was refused, the read error when the body was cut off, and
+// ErrCircuitOpen when the circuit breaker stopped the request.
+type Response struct {
+  Status int
+  Header map[string]string
+  Body string
+  Elapsed int64
+  Attempts int
+  // Bytes is the length of the body read from the API, which Body no
+  // longer reflects once it has been replaced by an error
+  Bytes int
+  Synthesized bool
+  Err os.Error
+}
+
+func synthesized(code int, text string) *Response {
+  return &Response{Status:code, Body:text, Synthesized:true}
+}
+
+func fetch(endpoint, api_key, url string) *Response {
   parsedUrl, err := http.ParseURL(url)
   if err != nil {
-    return http.StatusBadRequest, err.String()
+    return synthesized(http.StatusBadRequest, err.String())
   }
   headers := make(map[string]string)
   headers["Authorization"] = api_key
@@ -365,65 +553,377 @@ func retrieve(api_key, url string) (code int, text string) {
   }
   c, err := net.Dial("tcp", "", rapleaf_host + ":" + rapleaf_port)
   if err != nil {
-    return http.StatusServiceUnavailable, err.String()
+    return synthesized(http.StatusServiceUnavailable, err.String())
   }
   conn := http.NewClientConn(c, nil)
   if err := conn.Write(req); err != nil {
-    return http.StatusServiceUnavailable, err.String()
+    return synthesized(http.StatusServiceUnavailable, err.String())
   }
   resp, err := conn.Read()
   if resp == nil {
-    if err == nil {
-      return http.StatusServiceUnavailable, err.String()
+    if err != nil {
+      return synthesized(http.StatusServiceUnavailable, err.String())
     }
-    return http.StatusNoContent, ""
+    return synthesized(http.StatusNoContent, "")
+  }
+  r := &Response{Status:resp.StatusCode, Header:make(map[string]string)}
+  for k, v := range resp.Header {
+    r.Header[k] = v
+  }
+  var body io.Reader = resp.Body
+  limit := currentMaxBodySize()
+  if limit > 0 {
+    // one byte over the limit tells a full body from a cut one
+    body = io.LimitReader(resp.Body, limit + 1)
   }
-  buf, err := ioutil.ReadAll(resp.Body)
+  buf, err := ioutil.ReadAll(body)
+  r.Bytes = len(buf)
   if err != nil {
-    return resp.StatusCode, err.String()
+    return refused(r, err)
+  }
+  content_type := resp.Header["Content-Type"]
+  if limit > 0 && int64(len(buf)) > limit {
+    return refused(r, newContentError(ErrBodyTooLarge, resp.StatusCode, content_type, buf))
+  }
+  // only a 200 body is parsed, so only a 200 must say it is what the API
+  // sends; a 202 or 204 passes through whatever its headers
+  if resp.StatusCode == http.StatusOK && (len(content_type) == 0 ||
+      !currentApiVersion().AcceptsContentType(endpoint, mediaType(content_type))) {
+    return refused(r, newContentError(ErrUnexpectedContent, resp.StatusCode, content_type, buf))
   }
-  return resp.StatusCode, string(buf)
+  r.Body = string(buf)
+  return r
 }
 
+// refused turns r into a synthesized 502 carrying err, keeping the headers
+// that came back.
+func refused(r *Response, err os.Error) *Response {
+  r.Status = http.StatusBadGateway
+  r.Body = err.String()
+  r.Synthesized = true
+  r.Err = err
+  return r
+}
+
+// retrieve makes the request, polling again while the API answers 202 if
+// SetPolling asked for it.  Each wait and the requests after it are traced
+// under a "rapleaf.poll" span.
+func (tag CallerTag) retrieve(parent Span, endpoint, api_key, url string) *Response {
+  polls, interval := currentPolling()
+  r := tag.retrieveWithKeys(parent, endpoint, api_key, url)
+  for i := 1; i <= polls && r.Status == http.StatusAccepted; i++ {
+    span := startSpan(parent, "rapleaf.poll")
+    span.SetAttribute("rapleaf.endpoint", endpoint)
+    span.SetAttribute("rapleaf.poll", strconv.Itoa(i))
+    time.Sleep(interval)
+    next := tag.retrieveWithKeys(span, endpoint, api_key, url)
+    span.SetAttribute("http.status_code", strconv.Itoa(next.Status))
+    span.End()
+    next.Elapsed += r.Elapsed
+    next.Attempts += r.Attempts
+    r = next
+  }
+  return r
+}
+
+func (tag CallerTag) retrieveWithKeys(parent Span, endpoint, api_key, url string) *Response {
+  pool := currentKeyPool()
+  if len(api_key) > 0 || pool == nil {
+    return tag.attempt(parent, endpoint, api_key, url, 1)
+  }
+  r := synthesized(http.StatusForbidden, ErrNoApiKey.String())
+  elapsed := int64(0)
+  attempts := 0
+  for i := 0; i < pool.Len(); i++ {
+    key, ok := pool.pick()
+    if !ok {
+      break
+    }
+    attempts++
+    r = tag.attempt(parent, endpoint, key, url, attempts)
+    elapsed += r.Elapsed
+    pool.report(key, r.Status)
+    if r.Status != http.StatusUnauthorized && r.Status != http.StatusForbidden {
+      break
+    }
+  }
+  r.Elapsed = elapsed
+  r.Attempts = attempts
+  return r
+}
+
+// attempt makes one request; n counts it among the attempts of the lookup.
+func (tag CallerTag) attempt(parent Span, endpoint, api_key, url string, n int) *Response {
+  span := startSpan(parent, "rapleaf.http")
+  span.SetAttribute("rapleaf.endpoint", endpoint)
+  span.SetAttribute("rapleaf.key", KeyId(api_key))
+  b := currentCircuitBreaker()
+  if b != nil && !b.Allow() {
+    span.SetAttribute("rapleaf.circuit", CIRCUIT_OPEN.String())
+    span.SetAttribute("http.status_code", strconv.Itoa(http.StatusServiceUnavailable))
+    span.End()
+    r := synthesized(http.StatusServiceUnavailable, ErrCircuitOpen.String())
+    r.Err = ErrCircuitOpen
+    r.Attempts = 1
+    return r
+  }
+  start := time.Nanoseconds()
+  r := fetch(endpoint, api_key, url)
+  r.Elapsed = time.Nanoseconds() - start
+  r.Attempts = 1
+  if b != nil {
+    b.Record(!failedStatus(r.Status))
+  }
+  span.SetAttribute("http.status_code", strconv.Itoa(r.Status))
+  span.End()
+  recordUsage(endpoint, tag, r.Status)
+  logExchange(&Exchange{
+    Method:"GET",
+    Url:url,
+    Endpoint:endpoint,
+    Caller:string(tag),
+    Status:r.Status,
+    Latency:r.Elapsed,
+    Attempts:n,
+    Bytes:r.Bytes,
+  }, api_key, r.Body)
+  return r
+}
+
+// A CallerTag attributes lookups to a part of the application in the usage
+// counters; see Usage.  The package level lookups are untagged.
+type CallerTag string
+
 func PersonXmlByEmail(api_key, email_address string) (int, string) {
-  url := personUrl("email/", http.URLEscape(email_address))
-  return retrieve(api_key, url)
+  return CallerTag("").PersonXmlByEmail(api_key, email_address)
 }
 
 func PersonXmlByRapleafId(api_key, rapleaf_id string) (int, string) {
-  return PersonXmlBySite(api_key, "rapleaf", rapleaf_id)
+  return CallerTag("").PersonXmlByRapleafId(api_key, rapleaf_id)
 }
 
 func PersonXmlBySite(api_key, site, profile_id string) (int, string) {
-  url := personUrl("web/", http.URLEscape(site), "/", http.URLEscape(profile_id))
-  return retrieve(api_key, url)
+  return CallerTag("").PersonXmlBySite(api_key, site, profile_id)
 }
 
 func PersonByEmail(api_key, email_address string) (*RapleafPerson) {
-  code, text := PersonXmlByEmail(api_key, email_address)
-  if code == http.StatusOK {
-    u, err := RapleafPersonFromString(text)
-    if err == nil && u != nil {
-      u.EmailAddress = email_address
-      return u
-    }
-    return u
-  }
-  return nil
+  return CallerTag("").PersonByEmail(api_key, email_address)
 }
 
 func PersonByRapleafId(api_key, rapleaf_id string) (*RapleafPerson) {
-  return PersonBySite(api_key, "rapleaf", rapleaf_id)
+  return CallerTag("").PersonByRapleafId(api_key, rapleaf_id)
 }
 
 func PersonBySite(api_key, site, profile_id string) (*RapleafPerson) {
-  code, text := PersonXmlBySite(api_key, site, profile_id)
-  if code == http.StatusOK {
-    u, err := RapleafPersonFromString(text)
-    if err == nil {
-      return u
+  return CallerTag("").PersonBySite(api_key, site, profile_id)
+}
+
+func PersonByEmailWithResponse(api_key, email_address string) (*RapleafPerson, *Response) {
+  return CallerTag("").PersonByEmailWithResponse(api_key, email_address)
+}
+
+func PersonByRapleafIdWithResponse(api_key, rapleaf_id string) (*RapleafPerson, *Response) {
+  return CallerTag("").PersonByRapleafIdWithResponse(api_key, rapleaf_id)
+}
+
+func PersonBySiteWithResponse(api_key, site, profile_id string) (*RapleafPerson, *Response) {
+  return CallerTag("").PersonBySiteWithResponse(api_key, site, profile_id)
+}
+
+func GraphTextByEmailOrRapleafId(api_key, email_or_rapleaf_id string, n int) (int, string) {
+  return CallerTag("").GraphTextByEmailOrRapleafId(api_key, email_or_rapleaf_id, n)
+}
+
+func GraphByEmailOrRapleafIdWithResponse(api_key, email_or_rapleaf_id string, n int) *Response {
+  return CallerTag("").GraphByEmailOrRapleafIdWithResponse(api_key, email_or_rapleaf_id, n)
+}
+
+func RapleafIdsByGraph(api_key, email_or_rapleaf_id string) []string {
+  return CallerTag("").RapleafIdsByGraph(api_key, email_or_rapleaf_id)
+}
+
+func EmailAddressesByGraph(api_key, email_or_rapleaf_id string) []string {
+  return CallerTag("").EmailAddressesByGraph(api_key, email_or_rapleaf_id)
+}
+
+func RapleafIdsByGraphWithResponse(api_key, email_or_rapleaf_id string) ([]string, *Response) {
+  return CallerTag("").RapleafIdsByGraphWithResponse(api_key, email_or_rapleaf_id)
+}
+
+func EmailAddressesByGraphWithResponse(api_key, email_or_rapleaf_id string) ([]string, *Response) {
+  return CallerTag("").EmailAddressesByGraphWithResponse(api_key, email_or_rapleaf_id)
+}
+
+func personByEmailUrl(email_address string) string {
+  return currentApiVersion().PersonByEmailUrl(apiBase(), email_address)
+}
+
+func personBySiteUrl(site, profile_id string) string {
+  return currentApiVersion().PersonBySiteUrl(apiBase(), NormalizeSite(site).ShortName(), profile_id)
+}
+
+func (tag CallerTag) personXmlByEmail(span Span, api_key, email_address string) *Response {
+  return tag.retrieve(span, ENDPOINT_EMAIL, api_key, personByEmailUrl(email_address))
+}
+
+func (tag CallerTag) personXmlBySite(span Span, api_key, site, profile_id string) *Response {
+  return tag.retrieve(span, ENDPOINT_WEB, api_key, personBySiteUrl(site, profile_id))
+}
+
+func (tag CallerTag) graphText(span Span, api_key, email_or_rapleaf_id string, n int) *Response {
+  url := currentApiVersion().GraphUrl(apiBase(), email_or_rapleaf_id, n)
+  return tag.retrieve(span, ENDPOINT_GRAPH, api_key, url)
+}
+
+func parsePerson(parent Span, r *Response) *RapleafPerson {
+  if r.Status != http.StatusOK {
+    return nil
+  }
+  span := startSpan(parent, "rapleaf.parse")
+  defer span.End()
+  u, warnings, err := DecodePerson(r.Body, ParseOptions{})
+  if err != nil {
+    span.SetAttribute("error", err.String())
+    return nil
+  }
+  if len(warnings) > 0 {
+    span.SetAttribute("rapleaf.parse_warnings", strconv.Itoa(len(warnings)))
+  }
+  return u
+}
+
+// endLookupSpan records the outcome of a lookup on its span and ends it.
+// The email or profile id looked up is deliberately left off.
+func endLookupSpan(span Span, endpoint string, code int, u *RapleafPerson) {
+  span.SetAttribute("rapleaf.endpoint", endpoint)
+  span.SetAttribute("http.status_code", strconv.Itoa(code))
+  if u != nil && len(u.Id) > 0 {
+    span.SetAttribute("rapleaf.id", u.Id)
+  }
+  span.End()
+}
+
+func (tag CallerTag) PersonXmlByEmail(api_key, email_address string) (int, string) {
+  span := startSpan(nil, "rapleaf.PersonXmlByEmail")
+  r := tag.personXmlByEmail(span, api_key, email_address)
+  endLookupSpan(span, ENDPOINT_EMAIL, r.Status, nil)
+  return r.Status, r.Body
+}
+
+func (tag CallerTag) PersonXmlByRapleafId(api_key, rapleaf_id string) (int, string) {
+  span := startSpan(nil, "rapleaf.PersonXmlByRapleafId")
+  r := tag.personXmlBySite(span, api_key, "rapleaf", rapleaf_id)
+  endLookupSpan(span, ENDPOINT_WEB, r.Status, nil)
+  return r.Status, r.Body
+}
+
+func (tag CallerTag) PersonXmlBySite(api_key, site, profile_id string) (int, string) {
+  span := startSpan(nil, "rapleaf.PersonXmlBySite")
+  r := tag.personXmlBySite(span, api_key, site, profile_id)
+  endLookupSpan(span, ENDPOINT_WEB, r.Status, nil)
+  return r.Status, r.Body
+}
+
+func (tag CallerTag) PersonByEmail(api_key, email_address string) (*RapleafPerson) {
+  u, _ := tag.PersonByEmailWithResponse(api_key, email_address)
+  return u
+}
+
+func (tag CallerTag) PersonByRapleafId(api_key, rapleaf_id string) (*RapleafPerson) {
+  u, _ := tag.PersonByRapleafIdWithResponse(api_key, rapleaf_id)
+  return u
+}
+
+func (tag CallerTag) PersonBySite(api_key, site, profile_id string) (*RapleafPerson) {
+  u, _ := tag.PersonBySiteWithResponse(api_key, site, profile_id)
+  return u
+}
+
+func (tag CallerTag) PersonByEmailWithResponse(api_key, email_address string) (*RapleafPerson, *Response) {
+  span := startSpan(nil, "rapleaf.PersonByEmail")
+  u, r := tag.personByEmail(span, api_key, email_address)
+  endLookupSpan(span, ENDPOINT_EMAIL, r.Status, u)
+  return u, r
+}
+
+func (tag CallerTag) PersonByRapleafIdWithResponse(api_key, rapleaf_id string) (*RapleafPerson, *Response) {
+  span := startSpan(nil, "rapleaf.PersonByRapleafId")
+  u, r := tag.personBySite(span, api_key, "rapleaf", rapleaf_id)
+  endLookupSpan(span, ENDPOINT_WEB, r.Status, u)
+  return u, r
+}
+
+func (tag CallerTag) PersonBySiteWithResponse(api_key, site, profile_id string) (*RapleafPerson, *Response) {
+  span := startSpan(nil, "rapleaf.PersonBySite")
+  u, r := tag.personBySite(span, api_key, site, profile_id)
+  endLookupSpan(span, ENDPOINT_WEB, r.Status, u)
+  return u, r
+}
+
+func (tag CallerTag) personByEmail(span Span, api_key, email_address string) (*RapleafPerson, *Response) {
+  r := tag.personXmlByEmail(span, api_key, email_address)
+  u := parsePerson(span, r)
+  if u != nil {
+    u.EmailAddress = email_address
+  }
+  return u, r
+}
+
+func (tag CallerTag) personBySite(span Span, api_key, site, profile_id string) (*RapleafPerson, *Response) {
+  r := tag.personXmlBySite(span, api_key, site, profile_id)
+  return parsePerson(span, r), r
+}
+
+func (tag CallerTag) GraphTextByEmailOrRapleafId(api_key, email_or_rapleaf_id string, n int) (int, string) {
+  r := tag.GraphByEmailOrRapleafIdWithResponse(api_key, email_or_rapleaf_id, n)
+  return r.Status, r.Body
+}
+
+func (tag CallerTag) GraphByEmailOrRapleafIdWithResponse(api_key, email_or_rapleaf_id string, n int) *Response {
+  span := startSpan(nil, "rapleaf.GraphTextByEmailOrRapleafId")
+  r := tag.graphText(span, api_key, email_or_rapleaf_id, n)
+  endLookupSpan(span, ENDPOINT_GRAPH, r.Status, nil)
+  return r
+}
+
+func splitGraphText(text, sep string) []string {
+  values := strings.Split(strings.TrimSpace(text), sep, -1)
+  n := 0
+  for _, value := range values {
+    if value = strings.TrimSpace(value); len(value) > 0 {
+      values[n] = value
+      n++
     }
   }
-  return nil
+  return values[0:n]
 }
 
+func (tag CallerTag) graphValues(name, sep string, api_key, email_or_rapleaf_id string, n int) ([]string, *Response) {
+  span := startSpan(nil, name)
+  r := tag.graphText(span, api_key, email_or_rapleaf_id, n)
+  var values []string
+  if r.Status == http.StatusOK {
+    values = splitGraphText(r.Body, sep)
+    span.SetAttribute("rapleaf.results", strconv.Itoa(len(values)))
+  }
+  endLookupSpan(span, ENDPOINT_GRAPH, r.Status, nil)
+  return values, r
+}
+
+func (tag CallerTag) RapleafIdsByGraph(api_key, email_or_rapleaf_id string) []string {
+  values, _ := tag.RapleafIdsByGraphWithResponse(api_key, email_or_rapleaf_id)
+  return values
+}
+
+func (tag CallerTag) EmailAddressesByGraph(api_key, email_or_rapleaf_id string) []string {
+  values, _ := tag.EmailAddressesByGraphWithResponse(api_key, email_or_rapleaf_id)
+  return values
+}
+
+func (tag CallerTag) RapleafIdsByGraphWithResponse(api_key, email_or_rapleaf_id string) ([]string, *Response) {
+  return tag.graphValues("rapleaf.RapleafIdsByGraph", "\n", api_key, email_or_rapleaf_id, graphRapleafIds)
+}
+
+func (tag CallerTag) EmailAddressesByGraphWithResponse(api_key, email_or_rapleaf_id string) ([]string, *Response) {
+  return tag.graphValues("rapleaf.EmailAddressesByGraph", ",", api_key, email_or_rapleaf_id, graphEmailAddresses)
+}
@@ -51,59 +51,73 @@ var (
 var (
   USER_EMPTY_PERSON = &RapleafPerson{
     Id:"b34282025d7e2c5db6786a8daaab48c7", 
+    NumFriends:Known(0),
     EarliestKnownActivity:&time.Time{Year: 2010, Month: 5, Day: 27},
     Memberships:[]*RapleafMemberSite{
       &RapleafMemberSite{
         Site:"bebo.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"facebook.com",
         Exists:"unknown",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"flickr.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"friendster.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"hi5.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"linkedin.com",
         Exists:"tbd",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"livejournal.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"metroflog.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"multiply.com",
         Exists:"unknown",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"myspace.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"myyearbook.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"plaxo.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"twitter.com",
         Exists:"unknown",
+        Primary:true,
       },
     },
   }
@@ -113,8 +127,8 @@ var (
     Name:"John Q Public",
     Gender:"male",
     Location:"Albuquerque, New Mexico, United States",
-    NumFriends:156,
-    Age:28,
+    NumFriends:Known(156),
+    Age:Known(28),
     EarliestKnownActivity:&time.Time{Year:2001, Month:11, Day:16},
     LatestKnownActivity:&time.Time{Year:2010, Month:5, Day:8},
     Occupations:[]*RapleafOccupation{
@@ -135,63 +149,76 @@ var (
       &RapleafMemberSite{
         Site:"bebo.com", 
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"facebook.com", 
         Exists:"true",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"flickr.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"friendster.com",
         ProfileUrl:"http://profiles.friendster.com/3543228",
         ImageUrl:"http://photos.friendster.com/photos/82/11/3543228/13281738852124s.jpg",
-        NumFriends:16,
+        NumFriends:Known(16),
         Exists:"true",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"hi5.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"linkedin.com",
         ProfileUrl:"http://www.linkedin.com/in/johnqpublic",
         ImageUrl:"http://media.linkedin.com/mpr/mpr/shrink_80_80/p/2/000/016/0f0/36426ef.jpg",
-        NumFriends:166,
+        NumFriends:Known(166),
         Exists:"true",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"livejournal.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"metroflog.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"multiply.com",
          Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"myspace.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"myyearbook.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"plaxo.com",
         Exists:"false",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"twitter.com",
         ProfileUrl:"http://twitter.com/johnqpublic",
-        NumFollowers:14,
-        NumFollowed:4,
+        NumFollowers:Known(14),
+        NumFollowed:Known(4),
         Exists:"true",
+        Primary:true,
       },
       &RapleafMemberSite{
         Site:"pandora.com",
@@ -201,45 +228,38 @@ var (
       &RapleafMemberSite{
         Site:"tagged.com",
         ProfileUrl:"http://www.tagged.com/profile.html?uid=5378192615",
+        NumFriends:Known(0),
+        NumFollowers:Known(0),
+        NumFollowed:Known(0),
         Exists:"true",
       },
     },
   }
 )
 
-func ServeTestHTTP(conn *http.Conn, req *http.Request) {
-  req.Close = true
-  if api_key, ok := req.Header["Authorization"]; !ok || api_key != API_KEY {
-    text := []byte(ERROR_CODES[http.StatusUnauthorized])
-    conn.SetHeader("Content-Type", "text/html;charset=ISO-8859-1")
-    conn.SetHeader("Cache-Control", "must-revalidate,no-cache,no-store")
-    conn.SetHeader("Content-Length", strconv.Itoa(len(text)))
-    conn.WriteHeader(http.StatusUnauthorized)
-    conn.Write(text)
-    conn.Flush()
-    return
-  }
-  if text, ok := URL_MAPPINGS[req.URL.Path]; ok {
-    conn.SetHeader("Content-Type", "application/xml;charset=UTF-8")
-    conn.SetHeader("Transfer-Encoding", "chunked")
-    // for some reason, api.rapleaf.com does not send Content-Length
-    // when sending stored data
-    conn.WriteHeader(http.StatusOK)
-    conn.Write([]byte(text))
-    conn.Flush()
-    return
+var (
+  testServer *MockServer
+)
+
+func testMockServer() *MockServer {
+  if testServer == nil {
+    testServer = NewMockServer(API_KEY)
+    for urlPath, text := range URL_MAPPINGS {
+      testServer.AddFixture(urlPath, "application/xml;charset=UTF-8", text)
+    }
   }
-  text := []byte(ERROR_CODES[http.StatusNotFound])
-  conn.SetHeader("Content-Type", "text/html;charset=ISO-8859-1")
-  conn.SetHeader("Cache-Control", "must-revalidate,no-cache,no-store")
-  conn.SetHeader("Content-Length", strconv.Itoa(len(text)))
-  conn.WriteHeader(http.StatusNotFound)
-  conn.Write(text)
-  conn.Flush()
-  return
+  return testServer
+}
+
+func ServeTestHTTP(conn *http.Conn, req *http.Request) {
+  testMockServer().ServeHTTP(conn, req)
 }
 
 func serveTestFiles(t *testing.T) (l net.Listener, err os.Error) {
+  return serveTestHandler(t, http.HandlerFunc(ServeTestHTTP))
+}
+
+func serveTestHandler(t *testing.T, handler http.Handler) (l net.Listener, err os.Error) {
   foundValidPort := false
   var port_str string
   for !foundValidPort {
@@ -261,7 +281,7 @@ func serveTestFiles(t *testing.T) (l net.Listener, err os.Error) {
     foundValidPort = true
   }
   OverrideRapleafHostPort("127.0.0.1", port_str)
-  go http.Serve(l, http.HandlerFunc(ServeTestHTTP))
+  go http.Serve(l, handler)
   return l, err
 }
 
@@ -308,18 +328,21 @@ func testSameMemberSite(t *testing.T, expected, found *RapleafMemberSite) {
   if expected.ImageUrl != found.ImageUrl {
     t.Errorf("Expected image url %s but found %s in membership", expected.ImageUrl, found.ImageUrl)
   }
-  if expected.NumFriends != found.NumFriends {
-    t.Errorf("Expected num friends %d but found %d in membership", expected.NumFriends, found.NumFriends)
+  if !expected.NumFriends.Equals(found.NumFriends) {
+    t.Errorf("Expected num friends %q but found %q in membership", expected.NumFriends.String(), found.NumFriends.String())
   }
-  if expected.NumFollowers != found.NumFollowers {
-    t.Errorf("Expected num followers %d but found %d in membership", expected.NumFollowers, found.NumFollowers)
+  if !expected.NumFollowers.Equals(found.NumFollowers) {
+    t.Errorf("Expected num followers %q but found %q in membership", expected.NumFollowers.String(), found.NumFollowers.String())
   }
-  if expected.NumFollowed != found.NumFollowed {
-    t.Errorf("Expected num followed %d but found %d in membership", expected.NumFollowed, found.NumFollowed)
+  if !expected.NumFollowed.Equals(found.NumFollowed) {
+    t.Errorf("Expected num followed %q but found %q in membership", expected.NumFollowed.String(), found.NumFollowed.String())
   }
   if expected.Exists != found.Exists {
     t.Errorf("Expected exists %s but found %s in membership", expected.Exists, found.Exists)
   }
+  if expected.Primary != found.Primary {
+    t.Errorf("Expected primary %t but found %t in membership %s", expected.Primary, found.Primary, expected.Site)
+  }
 } 
 
 func testSamePerson(t *testing.T, expected, found *RapleafPerson) {
@@ -346,11 +369,11 @@ func testSamePerson(t *testing.T, expected, found *RapleafPerson) {
   if expected.Location != found.Location {
     t.Errorf("Expected location %s but found %s in person", expected.Location, found.Location)
   }
-  if expected.NumFriends != found.NumFriends {
-    t.Errorf("Expected num friends %d but found %d in person", expected.NumFriends, found.NumFriends)
+  if !expected.NumFriends.Equals(found.NumFriends) {
+    t.Errorf("Expected num friends %q but found %q in person", expected.NumFriends.String(), found.NumFriends.String())
   }
-  if expected.Age != found.Age {
-    t.Errorf("Expected age %d but found %d in person", expected.Age, found.Age)
+  if !expected.Age.Equals(found.Age) {
+    t.Errorf("Expected age %q but found %q in person", expected.Age.String(), found.Age.String())
   }
   if expected.EmailAddress != found.EmailAddress {
     t.Errorf("Expected email address %s but found %s in person", expected.EmailAddress, found.EmailAddress)
@@ -527,3 +550,100 @@ func TestPersonBySite(t *testing.T) {
   testSamePerson(t, USER_WITH_PROFILE_PERSON, PersonBySite(API_KEY, "linkedin", "johnqpublic"))
   closeServerTestFiles(l)
 }
+
+func TestMemberSiteEqualsComparesSite(t *testing.T) {
+  a := &RapleafMemberSite{Site:"bebo.com", Exists:"false"}
+  b := &RapleafMemberSite{Site:"flickr.com", Exists:"false"}
+  if a.Equals(b) {
+    t.Error("Expected memberships of different sites not to be equal")
+  }
+}
+
+func TestPersonEqualsUnordered(t *testing.T) {
+  reordered := *USER_WITH_PROFILE_PERSON
+  n := len(USER_WITH_PROFILE_PERSON.Memberships)
+  reordered.Memberships = make([]*RapleafMemberSite, n)
+  for i, membership := range USER_WITH_PROFILE_PERSON.Memberships {
+    reordered.Memberships[n - 1 - i] = membership
+  }
+  reordered.Occupations = []*RapleafOccupation{
+    USER_WITH_PROFILE_PERSON.Occupations[2],
+    USER_WITH_PROFILE_PERSON.Occupations[0],
+    USER_WITH_PROFILE_PERSON.Occupations[1],
+  }
+  if USER_WITH_PROFILE_PERSON.Equals(&reordered) {
+    t.Error("Expected ordered comparison to tell reordered records apart")
+  }
+  if !USER_WITH_PROFILE_PERSON.EqualsWith(&reordered, EqualsOptions{Unordered:true}) {
+    t.Error("Expected unordered comparison to find reordered records equal")
+  }
+  recased := *USER_WITH_PROFILE_PERSON.Occupations[0]
+  recased.Company = " " + strings.ToUpper(recased.Company)
+  reordered.Occupations[1] = &recased
+  if !USER_WITH_PROFILE_PERSON.EqualsWith(&reordered, EqualsOptions{Unordered:true}) {
+    t.Error("Expected unordered comparison to key occupations as Merge does")
+  }
+  reordered.Occupations = []*RapleafOccupation{
+    USER_WITH_PROFILE_PERSON.Occupations[0],
+    USER_WITH_PROFILE_PERSON.Occupations[0],
+    USER_WITH_PROFILE_PERSON.Occupations[1],
+  }
+  if USER_WITH_PROFILE_PERSON.EqualsWith(&reordered, EqualsOptions{Unordered:true}) {
+    t.Error("Expected unordered comparison to count repeated occupations")
+  }
+}
+
+func TestPersonEqualsIgnoreCounters(t *testing.T) {
+  changed := *USER_WITH_PROFILE_PERSON
+  changed.NumFriends = Known(200)
+  twitter := *USER_WITH_PROFILE_PERSON.Memberships[12]
+  twitter.NumFollowers = Known(20)
+  changed.Memberships = make([]*RapleafMemberSite, len(USER_WITH_PROFILE_PERSON.Memberships))
+  copy(changed.Memberships, USER_WITH_PROFILE_PERSON.Memberships)
+  changed.Memberships[12] = &twitter
+  if USER_WITH_PROFILE_PERSON.Equals(&changed) {
+    t.Error("Expected changed counters to make records unequal")
+  }
+  if !USER_WITH_PROFILE_PERSON.EqualsWith(&changed, EqualsOptions{IgnoreCounters:true}) {
+    t.Error("Expected records differing only in counters to be equal when ignoring counters")
+  }
+  changed.Location = "Santa Fe, New Mexico, United States"
+  if USER_WITH_PROFILE_PERSON.EqualsWith(&changed, EqualsOptions{IgnoreCounters:true, Unordered:true}) {
+    t.Error("Expected a changed location to make records unequal")
+  }
+}
+
+func TestParseWarnings(t *testing.T) {
+  garbled := strings.Replace(USER_WITH_PROFILE_XML, "<num_friends>156</num_friends>", "<num_friends>lots</num_friends>", 1)
+  garbled = strings.Replace(garbled, "2001-11-16", "16/11/2001", 1)
+  garbled = strings.Replace(garbled, "num_followers=\"14\"", "num_followers=\"14k\"", 1)
+  u, warnings, err := RapleafPersonFromStringWith(garbled, ParseOptions{})
+  if err != nil || u == nil {
+    t.Errorf("Expected a lenient parse to succeed but found %v", err)
+    return
+  }
+  expected := []string{
+    "EarliestKnownActivity 16/11/2001",
+    "Memberships[twitter.com].NumFollowers 14k",
+    "NumFriends lots",
+  }
+  if len(warnings) != len(expected) {
+    t.Errorf("Expected %d warnings but found %v", len(expected), warnings)
+    return
+  }
+  for i, w := range warnings {
+    if w.Field + " " + w.Value != expected[i] || w.Err == nil {
+      t.Errorf("Expected warning %s but found %s", expected[i], w.String())
+    }
+  }
+  if u.NumFriends.Valid() || u.Memberships[12].NumFollowers.Valid() || u.EarliestKnownActivity != nil {
+    t.Errorf("Expected invalid values to be left unknown but found %v", u)
+  }
+  u, warnings, err = RapleafPersonFromStringWith(garbled, ParseOptions{Strict:true})
+  if _, ok := err.(ParseWarnings); !ok || u != nil || len(warnings) != 3 {
+    t.Errorf("Expected a strict parse to fail with the warnings but found %v", err)
+  }
+  if _, warnings, _ = RapleafPersonFromStringWith(USER_EMPTY_XML, ParseOptions{Strict:true}); len(warnings) != 0 {
+    t.Errorf("Expected absent values not to be warnings but found %v", warnings)
+  }
+}
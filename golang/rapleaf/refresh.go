@@ -0,0 +1,182 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "http"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// A Refresher re-queries stored persons once they are older than their
+// TTL, oldest first, and appends what the API returns to the store.
+type Refresher struct {
+  Store Store
+  ApiKey string
+  Tag CallerTag
+  // TTL is the age in seconds after which a record is refreshed
+  TTL int64
+  // StatusTTL overrides TTL by the status of the last fetch, so that a
+  // 202 can be retried within minutes while a 200 waits for weeks
+  StatusTTL map[int]int64
+  // FieldTTL overrides TTL while a field is still unknown in the record.
+  // Fields are named as in Diff paths, e.g. "LatestKnownActivity",
+  // "Memberships" or "Memberships[twitter.com]".  The shortest TTL that
+  // applies wins.
+  FieldTTL map[string]int64
+  // Budget bounds the number of lookups made by one Run; zero means no
+  // bound
+  Budget int
+  // OnChange, if set, is called for every refreshed record that changed
+  OnChange func(r *StoredPerson, changes []*Change)
+}
+
+// A RefreshReport summarizes one Run.
+type RefreshReport struct {
+  Stored int
+  Due int
+  Refreshed int
+  Changed int
+  // Deferred counts records that were due but left for a later run
+  Deferred int
+  // Failed counts records whose new version could not be parsed; they
+  // keep their stored version and stay due
+  Failed int
+}
+
+func (r *RefreshReport) String() string {
+  return "stored=" + strconv.Itoa(r.Stored) +
+    " due=" + strconv.Itoa(r.Due) +
+    " refreshed=" + strconv.Itoa(r.Refreshed) +
+    " changed=" + strconv.Itoa(r.Changed) +
+    " deferred=" + strconv.Itoa(r.Deferred) +
+    " failed=" + strconv.Itoa(r.Failed)
+}
+
+func NewRefresher(store Store, api_key string, ttl int64) *Refresher {
+  return &Refresher{Store:store, ApiKey:api_key, TTL:ttl}
+}
+
+func knownField(changes []*Change, field string) bool {
+  for _, c := range changes {
+    if c.Path == field || strings.HasPrefix(c.Path, field + "[") || strings.HasPrefix(c.Path, field + ".") {
+      return true
+    }
+  }
+  return false
+}
+
+// TTLFor returns the TTL that applies to a stored record.
+func (f *Refresher) TTLFor(r *StoredPerson) int64 {
+  ttl := f.TTL
+  status := r.Status
+  if status == 0 {
+    status = http.StatusOK
+  }
+  if t, ok := f.StatusTTL[status]; ok {
+    ttl = t
+  }
+  if len(f.FieldTTL) > 0 {
+    // everything known in the record shows up as added against nothing
+    known := Diff(nil, r.Person)
+    for field, t := range f.FieldTTL {
+      if t < ttl && !knownField(known, field) {
+        ttl = t
+      }
+    }
+  }
+  return ttl
+}
+
+// Due reports whether a stored record should be refreshed at now, in
+// seconds since the epoch.
+func (f *Refresher) Due(r *StoredPerson, now int64) bool {
+  return now - r.FetchedAt >= f.TTLFor(r)
+}
+
+type byFetchedAt []*StoredPerson
+
+func (p byFetchedAt) Len() int { return len(p) }
+func (p byFetchedAt) Less(i, j int) bool { return p[i].FetchedAt < p[j].FetchedAt }
+func (p byFetchedAt) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+// Run refreshes due records, oldest first, until they are done or the
+// budget is spent.  It stops early with an error when the API refuses the
+// key, the quota is gone or the API is failing; records not reached are
+// counted as deferred.  A response that cannot be parsed only fails its
+// own record.  Responses are not kept: Run reports per record through the
+// RefreshReport and OnChange, and the status that stopped it through its
+// error.
+func (f *Refresher) Run() (*RefreshReport, os.Error) {
+  now := time.Seconds()
+  ids := f.Store.Ids()
+  report := &RefreshReport{Stored:len(ids)}
+  due := make([]*StoredPerson, 0, len(ids))
+  for _, id := range ids {
+    r, err := f.Store.ByRapleafId(id)
+    if err != nil {
+      return report, err
+    }
+    if r != nil && f.Due(r, now) {
+      due = due[0:len(due) + 1]
+      due[len(due) - 1] = r
+    }
+  }
+  sort.Sort(byFetchedAt(due))
+  report.Due = len(due)
+  report.Deferred = len(due)
+  for i, old := range due {
+    if f.Budget > 0 && i >= f.Budget {
+      break
+    }
+    code, text := f.Tag.PersonXmlByRapleafId(f.ApiKey, old.Person.Id)
+    if code == http.StatusUnauthorized || code == http.StatusForbidden || code >= http.StatusInternalServerError {
+      return report, os.NewError("rapleaf: refresh stopped with status " + strconv.Itoa(code) + ": " + ERROR_CODES[code])
+    }
+    next := &StoredPerson{Person:old.Person, FetchedAt:time.Seconds(), Status:code, Site:old.Site, ProfileId:old.ProfileId}
+    var changes []*Change
+    if code == http.StatusOK {
+      u, _, err := DecodePerson(text, ParseOptions{})
+      if err != nil {
+        report.Failed++
+        report.Deferred--
+        continue
+      }
+      if u != nil && len(u.Id) > 0 {
+        // lookups by id do not echo the email address
+        u.EmailAddress = old.Person.EmailAddress
+        changes = Diff(old.Person, u)
+        next.Person = u
+      }
+    }
+    if err := f.Store.Put(next); err != nil {
+      return report, err
+    }
+    report.Refreshed++
+    report.Deferred--
+    if len(changes) > 0 {
+      report.Changed++
+      if f.OnChange != nil {
+        f.OnChange(next, changes)
+      }
+    }
+  }
+  return report, nil
+}
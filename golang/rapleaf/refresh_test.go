@@ -0,0 +1,129 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "os"
+  "testing"
+  "time"
+)
+
+func TestRefresherDue(t *testing.T) {
+  f := NewRefresher(nil, API_KEY, 1000)
+  f.StatusTTL = map[int]int64{202:10}
+  f.FieldTTL = map[string]int64{"Memberships":100, "Name":5000}
+  found := &StoredPerson{Person:USER_WITH_PROFILE_PERSON, FetchedAt:0, Status:200}
+  if ttl := f.TTLFor(found); ttl != 1000 {
+    t.Errorf("Expected TTL 1000 for a complete record but found %d", ttl)
+  }
+  pending := &StoredPerson{Person:USER_WITH_PROFILE_PERSON, FetchedAt:0, Status:202}
+  if ttl := f.TTLFor(pending); ttl != 10 {
+    t.Errorf("Expected TTL 10 for a pending record but found %d", ttl)
+  }
+  sparse := &StoredPerson{Person:&RapleafPerson{Id:"b34282025d7e2c5db6786a8daaab48c7"}, FetchedAt:0}
+  if ttl := f.TTLFor(sparse); ttl != 100 {
+    t.Errorf("Expected TTL 100 for a record without memberships but found %d", ttl)
+  }
+  if f.Due(sparse, 99) || !f.Due(sparse, 100) {
+    t.Error("Expected a record to fall due once its TTL has elapsed")
+  }
+}
+
+func TestRefresherRun(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v3/person/web/rapleaf/97fc425100000000", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  server.AddFixture("/v3/person/web/rapleaf/b34282025d7e2c5db6786a8daaab48c7", "application/xml;charset=UTF-8", USER_EMPTY_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  defer closeServerTestFiles(l)
+  filename := testStoreFile()
+  defer os.Remove(filename)
+  s, err := OpenFileStore(filename)
+  if err != nil {
+    t.Error("Unable to open store: ", err.String())
+    return
+  }
+  defer s.Close()
+  now := time.Seconds()
+  old := *USER_WITH_PROFILE_PERSON
+  old.EmailAddress = "john.q.public@gmail.com"
+  old.NumFriends = Known(100)
+  s.Put(&StoredPerson{Person:&old, FetchedAt:now - 5000, Status:200})
+  s.Put(&StoredPerson{Person:USER_EMPTY_PERSON, FetchedAt:now - 2000, Status:200})
+  s.Put(&StoredPerson{Person:&RapleafPerson{Id:"0000000000000000"}, FetchedAt:now, Status:200})
+  f := NewRefresher(s, API_KEY, 1000)
+  f.Budget = 1
+  var changed []*Change
+  f.OnChange = func(r *StoredPerson, changes []*Change) {
+    changed = changes
+  }
+  report, err := f.Run()
+  if err != nil {
+    t.Error("Unexpected error refreshing: ", err.String())
+    return
+  }
+  if report.Stored != 3 || report.Due != 2 || report.Refreshed != 1 || report.Changed != 1 || report.Deferred != 1 {
+    t.Errorf("Unexpected report %s", report.String())
+  }
+  if len(changed) != 1 || changed[0].Path != "NumFriends" || changed[0].Old != "100" || changed[0].New != "156" {
+    t.Errorf("Expected only NumFriends to change but found:\n%s", DiffText(changed))
+  }
+  r, _ := s.ByRapleafId("97fc425100000000")
+  if r == nil || r.Person.NumFriends.Int() != 156 || r.Person.EmailAddress != "john.q.public@gmail.com" {
+    t.Errorf("Expected the refreshed record to be stored with its email address but found %v", r)
+  }
+  // the oldest record is now fresh, so the next run takes the other one
+  if report, _ = f.Run(); report.Refreshed != 1 || report.Changed != 0 || server.Calls() != 2 {
+    t.Errorf("Unexpected second report %s after %d calls", report.String(), server.Calls())
+  }
+}
+
+func TestRefresherRunParseFailure(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v3/person/web/rapleaf/97fc425100000000", "application/xml;charset=UTF-8", "<person id=\"97fc425100000000\"><basics>")
+  server.AddFixture("/v3/person/web/rapleaf/b34282025d7e2c5db6786a8daaab48c7", "application/xml;charset=UTF-8", USER_EMPTY_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  defer closeServerTestFiles(l)
+  filename := testStoreFile()
+  defer os.Remove(filename)
+  s, err := OpenFileStore(filename)
+  if err != nil {
+    t.Error("Unable to open store: ", err.String())
+    return
+  }
+  defer s.Close()
+  now := time.Seconds()
+  s.Put(&StoredPerson{Person:USER_WITH_PROFILE_PERSON, FetchedAt:now - 5000, Status:200})
+  s.Put(&StoredPerson{Person:USER_EMPTY_PERSON, FetchedAt:now - 2000, Status:200})
+  report, err := NewRefresher(s, API_KEY, 1000).Run()
+  if err != nil {
+    t.Error("Unexpected error refreshing: ", err.String())
+    return
+  }
+  if report.Due != 2 || report.Refreshed != 1 || report.Failed != 1 || report.Deferred != 0 {
+    t.Errorf("Expected one refreshed and one failed record but found %s", report.String())
+  }
+  if history, _ := s.History("97fc425100000000"); len(history) != 1 || history[0].FetchedAt != now - 5000 {
+    t.Errorf("Expected the failed record to keep its stored version but found %v", history)
+  }
+}
@@ -0,0 +1,103 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "http"
+  "strings"
+  "testing"
+)
+
+func TestLookupResponse(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  u, r := PersonByEmailWithResponse(API_KEY, "john.q.public@gmail.com")
+  _, missing := PersonBySiteWithResponse(API_KEY, "twitter", "nobody")
+  closeServerTestFiles(l)
+  expected := *USER_WITH_PROFILE_PERSON
+  expected.EmailAddress = "john.q.public@gmail.com"
+  testSamePerson(t, &expected, u)
+  if r.Status != 200 || r.Synthesized || r.Attempts != 1 || r.Elapsed <= 0 {
+    t.Errorf("Unexpected response metadata %v", r)
+  }
+  if strings.Index(r.Header["Content-Type"], "application/xml") != 0 {
+    t.Errorf("Expected the response content type but found headers %v", r.Header)
+  }
+  if strings.TrimSpace(r.Body) != USER_WITH_PROFILE_XML {
+    t.Errorf("Expected the raw response body but found %s", r.Body)
+  }
+  if missing.Status != 404 || missing.Synthesized {
+    t.Errorf("Expected a real 404 but found %v", missing)
+  }
+}
+
+func TestSynthesizedResponse(t *testing.T) {
+  l, err := serveTestHandler(t, NewMockServer(API_KEY))
+  if err != nil {
+    return
+  }
+  // nothing listens on the port once the server is closed
+  closeServerTestFiles(l)
+  r := GraphByEmailOrRapleafIdWithResponse(API_KEY, "john.q.public@gmail.com", 1)
+  if r.Status != 503 || !r.Synthesized || r.Attempts != 1 || r.Header != nil {
+    t.Errorf("Expected a synthesized 503 for a refused connection but found %v", r)
+  }
+}
+
+func TestCutOffBody(t *testing.T) {
+  l, err := serveTestHandler(t, http.HandlerFunc(func(conn *http.Conn, req *http.Request) {
+    rwc, buf, err := conn.Hijack()
+    if err != nil {
+      return
+    }
+    // the chunk promises more than is sent before the connection closes
+    buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/xml;charset=UTF-8\r\nTransfer-Encoding: chunked\r\n\r\n100\r\n<person")
+    buf.Flush()
+    rwc.Close()
+  }))
+  if err != nil {
+    return
+  }
+  u, r := PersonByEmailWithResponse(API_KEY, "john.q.public@gmail.com")
+  closeServerTestFiles(l)
+  if u != nil || r.Status != 502 || !r.Synthesized || r.Err == nil || r.Body != r.Err.String() {
+    t.Errorf("Expected a synthesized 502 carrying the read error but found %v", r)
+  }
+}
+
+func TestGraphValuesResponse(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v2/graph/john.q.public@gmail.com?n=1", "text/plain;charset=UTF-8", "97fc425100000000\nb34282025d7e2c5db6786a8daaab48c7\n")
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  ids, r := RapleafIdsByGraphWithResponse(API_KEY, "john.q.public@gmail.com")
+  missing, missing_response := EmailAddressesByGraphWithResponse(API_KEY, "nobody@gmail.com")
+  closeServerTestFiles(l)
+  if len(ids) != 2 || r.Status != 200 || r.Synthesized {
+    t.Errorf("Expected ids with a real 200 but found %v and %v", ids, r)
+  }
+  if len(missing) != 0 || missing_response.Status != 404 {
+    t.Errorf("Expected no emails with a 404 but found %v and %v", missing, missing_response)
+  }
+}
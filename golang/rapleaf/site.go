@@ -0,0 +1,115 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "strings"
+)
+
+// A Site names a social network the way memberships do, e.g.
+// "linkedin.com".  Lookups by site take either that or the short name the
+// API uses in its URLs, e.g. "linkedin"; NormalizeSite turns both into the
+// former.
+type Site string
+
+const (
+  SITE_BEBO = Site("bebo.com")
+  SITE_FACEBOOK = Site("facebook.com")
+  SITE_FLICKR = Site("flickr.com")
+  SITE_FRIENDSTER = Site("friendster.com")
+  SITE_HI5 = Site("hi5.com")
+  SITE_LINKEDIN = Site("linkedin.com")
+  SITE_LIVEJOURNAL = Site("livejournal.com")
+  SITE_METROFLOG = Site("metroflog.com")
+  SITE_MULTIPLY = Site("multiply.com")
+  SITE_MYSPACE = Site("myspace.com")
+  SITE_MYYEARBOOK = Site("myyearbook.com")
+  SITE_PLAXO = Site("plaxo.com")
+  SITE_TWITTER = Site("twitter.com")
+  SITE_PANDORA = Site("pandora.com")
+  SITE_TAGGED = Site("tagged.com")
+  // lookups by Rapleaf id go through the site lookup as "rapleaf"
+  SITE_RAPLEAF = Site("rapleaf.com")
+)
+
+var (
+  // the sites the API lists under <primary>, in its order
+  PRIMARY_SITES = []Site{
+    SITE_BEBO,
+    SITE_FACEBOOK,
+    SITE_FLICKR,
+    SITE_FRIENDSTER,
+    SITE_HI5,
+    SITE_LINKEDIN,
+    SITE_LIVEJOURNAL,
+    SITE_METROFLOG,
+    SITE_MULTIPLY,
+    SITE_MYSPACE,
+    SITE_MYYEARBOOK,
+    SITE_PLAXO,
+    SITE_TWITTER,
+  }
+)
+
+// NormalizeSite accepts a short name, a domain or a URL for a site, in any
+// case, and returns its canonical form.  A name without a dot is taken to
+// be a .com domain.
+func NormalizeSite(s string) Site {
+  s = strings.ToLower(strings.TrimSpace(s))
+  if i := strings.Index(s, "://"); i >= 0 {
+    s = s[i + 3:]
+  }
+  if i := strings.Index(s, "/"); i >= 0 {
+    s = s[0:i]
+  }
+  if strings.HasPrefix(s, "www.") {
+    s = s[len("www."):]
+  }
+  if len(s) > 0 && strings.Index(s, ".") < 0 {
+    s += ".com"
+  }
+  return Site(s)
+}
+
+// ShortName returns the name the API uses in lookup URLs, e.g. "linkedin".
+func (s Site) ShortName() string {
+  if i := strings.Index(string(s), "."); i >= 0 {
+    return string(s)[0:i]
+  }
+  return string(s)
+}
+
+func (s Site) Primary() bool {
+  for _, primary := range PRIMARY_SITES {
+    if s == primary {
+      return true
+    }
+  }
+  return false
+}
+
+// MembershipFor returns the membership on site, given in either form, or
+// nil if the person has none there.
+func (p *RapleafPerson) MembershipFor(site string) *RapleafMemberSite {
+  want := NormalizeSite(site)
+  for _, m := range p.Memberships {
+    if NormalizeSite(m.Site) == want {
+      return m
+    }
+  }
+  return nil
+}
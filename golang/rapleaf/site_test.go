@@ -0,0 +1,67 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "testing"
+)
+
+func TestNormalizeSite(t *testing.T) {
+  for raw, expected := range map[string]Site{
+    "linkedin":SITE_LINKEDIN,
+    "linkedin.com":SITE_LINKEDIN,
+    " LinkedIn.com ":SITE_LINKEDIN,
+    "http://www.linkedin.com/in/johnqpublic":SITE_LINKEDIN,
+    "hi5":SITE_HI5,
+    "rapleaf":SITE_RAPLEAF,
+    "":Site(""),
+  } {
+    if s := NormalizeSite(raw); s != expected {
+      t.Errorf("Expected %q to normalize to %s but found %s", raw, expected, s)
+    }
+  }
+  if SITE_MYYEARBOOK.ShortName() != "myyearbook" || !SITE_TWITTER.Primary() || SITE_TAGGED.Primary() {
+    t.Error("Unexpected short name or primary flag for known sites")
+  }
+}
+
+func TestMembershipFor(t *testing.T) {
+  for _, site := range []string{"twitter", "twitter.com", "Twitter.com"} {
+    m := USER_WITH_PROFILE_PERSON.MembershipFor(site)
+    if m == nil || m.Site != "twitter.com" {
+      t.Errorf("Expected the twitter.com membership for %q but found %v", site, m)
+    }
+  }
+  if m := USER_WITH_PROFILE_PERSON.MembershipFor("github"); m != nil {
+    t.Errorf("Expected no github membership but found %v", m)
+  }
+}
+
+func TestPersonBySiteEitherForm(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v3/person/web/linkedin/johnqpublic", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  short := PersonBySite(API_KEY, "linkedin", "johnqpublic")
+  long := PersonBySite(API_KEY, "linkedin.com", "johnqpublic")
+  closeServerTestFiles(l)
+  testSamePerson(t, USER_WITH_PROFILE_PERSON, short)
+  testSamePerson(t, USER_WITH_PROFILE_PERSON, long)
+}
@@ -0,0 +1,352 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "bufio"
+  "http"
+  "json"
+  "os"
+  "strconv"
+  "sync"
+  "time"
+)
+
+// A StoredPerson is one version of a person as fetched from the API.
+type StoredPerson struct {
+  Person *RapleafPerson "person"
+  // FetchedAt is in seconds since the epoch
+  FetchedAt int64 "fetched_at"
+  // Status is the API status of that fetch; records written by a refresh
+  // that found nothing keep the previous Person
+  Status int "status"
+  // Site and ProfileId are set when the person was looked up by profile
+  Site string "site"
+  ProfileId string "profile_id"
+}
+
+// A Store persists persons keyed by Rapleaf id, with secondary indexes by
+// email address and by site profile.  Lookups return the latest version;
+// History returns every version, oldest first.  A lookup that finds
+// nothing returns nil and no error.
+type Store interface {
+  Put(r *StoredPerson) os.Error
+  ByRapleafId(rapleaf_id string) (*StoredPerson, os.Error)
+  ByEmail(email_address string) (*StoredPerson, os.Error)
+  BySite(site, profile_id string) (*StoredPerson, os.Error)
+  History(rapleaf_id string) ([]*StoredPerson, os.Error)
+  // Ids returns the Rapleaf id of every person stored
+  Ids() []string
+  Close() os.Error
+}
+
+type logEntry struct {
+  offset int64
+  length int
+}
+
+// FileStore is a Store kept in a single append-only file of JSON records,
+// one per line, indexed in memory when opened.
+type FileStore struct {
+  file *os.File
+  size int64
+  versions map[string][]logEntry
+  byEmail map[string]string
+  bySite map[string]string
+  lock sync.RWMutex
+}
+
+func siteKey(site, profile_id string) string {
+  return string(NormalizeSite(site)) + "/" + profile_id
+}
+
+func OpenFileStore(filename string) (*FileStore, os.Error) {
+  f, err := os.Open(filename, os.O_RDWR | os.O_CREAT, 0644)
+  if err != nil {
+    return nil, err
+  }
+  s := &FileStore{
+    file:f,
+    versions:make(map[string][]logEntry),
+    byEmail:make(map[string]string),
+    bySite:make(map[string]string),
+  }
+  r := bufio.NewReader(f)
+  for {
+    line, err := r.ReadString('\n')
+    if err == os.EOF {
+      // a line without its newline is a write cut short; cut it off so
+      // a shorter record put over it leaves nothing behind
+      if len(line) > 0 {
+        if err = f.Truncate(s.size); err != nil {
+          f.Close()
+          return nil, err
+        }
+      }
+      break
+    }
+    if err != nil {
+      f.Close()
+      return nil, err
+    }
+    record := &StoredPerson{}
+    if err = json.Unmarshal([]byte(line), record); err != nil {
+      f.Close()
+      return nil, os.NewError(filename + ": corrupt record at offset " + strconv.Itoa64(s.size) + ": " + err.String())
+    }
+    s.index(record, logEntry{offset:s.size, length:len(line)})
+    s.size += int64(len(line))
+  }
+  return s, nil
+}
+
+// index must be called with s.lock held for writing.  Records without a
+// person, which Put refuses, are left out.
+func (s *FileStore) index(r *StoredPerson, e logEntry) {
+  if r.Person == nil || len(r.Person.Id) == 0 {
+    return
+  }
+  id := r.Person.Id
+  versions := s.versions[id]
+  grown := make([]logEntry, len(versions) + 1)
+  copy(grown, versions)
+  grown[len(versions)] = e
+  s.versions[id] = grown
+  if len(r.Person.EmailAddress) > 0 {
+    s.byEmail[r.Person.EmailAddress] = id
+  }
+  if len(r.Site) > 0 && len(r.ProfileId) > 0 {
+    s.bySite[siteKey(r.Site, r.ProfileId)] = id
+  }
+}
+
+func (s *FileStore) Put(r *StoredPerson) os.Error {
+  if r == nil || r.Person == nil || len(r.Person.Id) == 0 {
+    return os.NewError("rapleaf: cannot store a person without a Rapleaf id")
+  }
+  buf, err := json.Marshal(r)
+  if err != nil {
+    return err
+  }
+  line := string(buf) + "\n"
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  if _, err = s.file.WriteAt([]byte(line), s.size); err != nil {
+    return err
+  }
+  s.index(r, logEntry{offset:s.size, length:len(line)})
+  s.size += int64(len(line))
+  return nil
+}
+
+// read must be called with s.lock held.
+func (s *FileStore) read(e logEntry) (*StoredPerson, os.Error) {
+  buf := make([]byte, e.length)
+  if _, err := s.file.ReadAt(buf, e.offset); err != nil {
+    return nil, err
+  }
+  r := &StoredPerson{}
+  if err := json.Unmarshal(buf, r); err != nil {
+    return nil, err
+  }
+  return r, nil
+}
+
+func (s *FileStore) ByRapleafId(rapleaf_id string) (*StoredPerson, os.Error) {
+  s.lock.RLock()
+  defer s.lock.RUnlock()
+  versions, ok := s.versions[rapleaf_id]
+  if !ok {
+    return nil, nil
+  }
+  return s.read(versions[len(versions) - 1])
+}
+
+func (s *FileStore) ByEmail(email_address string) (*StoredPerson, os.Error) {
+  s.lock.RLock()
+  id, ok := s.byEmail[email_address]
+  s.lock.RUnlock()
+  if !ok {
+    return nil, nil
+  }
+  return s.ByRapleafId(id)
+}
+
+func (s *FileStore) BySite(site, profile_id string) (*StoredPerson, os.Error) {
+  s.lock.RLock()
+  id, ok := s.bySite[siteKey(site, profile_id)]
+  s.lock.RUnlock()
+  if !ok {
+    return nil, nil
+  }
+  return s.ByRapleafId(id)
+}
+
+func (s *FileStore) History(rapleaf_id string) ([]*StoredPerson, os.Error) {
+  s.lock.RLock()
+  defer s.lock.RUnlock()
+  versions := s.versions[rapleaf_id]
+  history := make([]*StoredPerson, len(versions))
+  for i, e := range versions {
+    r, err := s.read(e)
+    if err != nil {
+      return nil, err
+    }
+    history[i] = r
+  }
+  return history, nil
+}
+
+func (s *FileStore) Ids() []string {
+  s.lock.RLock()
+  defer s.lock.RUnlock()
+  ids := make([]string, len(s.versions))
+  i := 0
+  for id, _ := range s.versions {
+    ids[i] = id
+    i++
+  }
+  return ids
+}
+
+func (s *FileStore) Close() os.Error {
+  return s.file.Close()
+}
+
+// A StoreLookup answers lookups from a Store first and asks the API only
+// when the person is missing or was fetched more than MaxAge seconds ago,
+// storing what the API returns.  MaxAge zero means stored records never go
+// stale.  If the API cannot answer, a stale record is returned rather than
+// nothing.  A store that cannot be read counts as a miss; the error, or one
+// from storing the answer, is returned along with the person.
+type StoreLookup struct {
+  Store Store
+  ApiKey string
+  Tag CallerTag
+  MaxAge int64
+}
+
+func NewStoreLookup(store Store, api_key string, max_age int64) *StoreLookup {
+  return &StoreLookup{Store:store, ApiKey:api_key, MaxAge:max_age}
+}
+
+func (l *StoreLookup) fresh(r *StoredPerson) bool {
+  return r != nil && (l.MaxAge <= 0 || time.Seconds() - r.FetchedAt <= l.MaxAge)
+}
+
+// read fetches the stored record under a "rapleaf.store" span, marking it
+// a hit, a stale record or a miss.
+func (l *StoreLookup) read(parent Span, get func() (*StoredPerson, os.Error)) (*StoredPerson, os.Error) {
+  span := startSpan(parent, "rapleaf.store")
+  defer span.End()
+  stored, err := get()
+  if err != nil {
+    span.SetAttribute("rapleaf.cache", "miss")
+    span.SetAttribute("error", err.String())
+    return nil, err
+  }
+  switch {
+  case l.fresh(stored):
+    span.SetAttribute("rapleaf.cache", "hit")
+  case stored != nil:
+    span.SetAttribute("rapleaf.cache", "stale")
+  default:
+    span.SetAttribute("rapleaf.cache", "miss")
+  }
+  return stored, nil
+}
+
+// resolve answers from stored when it is fresh, logging the hit as an
+// exchange for url that never went out, and otherwise calls lookup.  It
+// ends span.  read_err is the error, if any, that left stored nil.  The
+// Response is nil when the API was not asked.
+func (l *StoreLookup) resolve(span Span, stored *StoredPerson, read_err os.Error, endpoint, url, site, profile_id string, lookup func() (*RapleafPerson, *Response)) (*RapleafPerson, *Response, os.Error) {
+  RecordCacheLookup(l.fresh(stored))
+  if l.fresh(stored) {
+    logExchange(&Exchange{
+      Method:"GET",
+      Url:url,
+      Endpoint:endpoint,
+      Caller:string(l.Tag),
+      Status:stored.Status,
+      CacheHit:true,
+    }, l.ApiKey, "")
+    endLookupSpan(span, endpoint, stored.Status, stored.Person)
+    return stored.Person, nil, nil
+  }
+  u, r := lookup()
+  endLookupSpan(span, endpoint, r.Status, u)
+  if u == nil || len(u.Id) == 0 {
+    if stored != nil {
+      return stored.Person, r, nil
+    }
+    return u, r, read_err
+  }
+  err := l.Store.Put(&StoredPerson{Person:u, FetchedAt:time.Seconds(), Status:http.StatusOK, Site:site, ProfileId:profile_id})
+  if read_err != nil {
+    return u, r, read_err
+  }
+  return u, r, err
+}
+
+func (l *StoreLookup) PersonByEmail(email_address string) (*RapleafPerson, os.Error) {
+  u, _, err := l.PersonByEmailWithResponse(email_address)
+  return u, err
+}
+
+func (l *StoreLookup) PersonByRapleafId(rapleaf_id string) (*RapleafPerson, os.Error) {
+  u, _, err := l.PersonByRapleafIdWithResponse(rapleaf_id)
+  return u, err
+}
+
+func (l *StoreLookup) PersonBySite(site, profile_id string) (*RapleafPerson, os.Error) {
+  u, _, err := l.PersonBySiteWithResponse(site, profile_id)
+  return u, err
+}
+
+// PersonByEmailWithResponse also returns what the API answered, or nil
+// when the person came from the store without asking.  A stale record
+// returned because the API could not answer comes with that answer.
+func (l *StoreLookup) PersonByEmailWithResponse(email_address string) (*RapleafPerson, *Response, os.Error) {
+  span := startSpan(nil, "rapleaf.PersonByEmail")
+  stored, err := l.read(span, func() (*StoredPerson, os.Error) {
+    return l.Store.ByEmail(email_address)
+  })
+  return l.resolve(span, stored, err, ENDPOINT_EMAIL, personByEmailUrl(email_address), "", "", func() (*RapleafPerson, *Response) {
+    return l.Tag.personByEmail(span, l.ApiKey, email_address)
+  })
+}
+
+func (l *StoreLookup) PersonByRapleafIdWithResponse(rapleaf_id string) (*RapleafPerson, *Response, os.Error) {
+  span := startSpan(nil, "rapleaf.PersonByRapleafId")
+  stored, err := l.read(span, func() (*StoredPerson, os.Error) {
+    return l.Store.ByRapleafId(rapleaf_id)
+  })
+  return l.resolve(span, stored, err, ENDPOINT_WEB, personBySiteUrl("rapleaf", rapleaf_id), "", "", func() (*RapleafPerson, *Response) {
+    return l.Tag.personBySite(span, l.ApiKey, "rapleaf", rapleaf_id)
+  })
+}
+
+func (l *StoreLookup) PersonBySiteWithResponse(site, profile_id string) (*RapleafPerson, *Response, os.Error) {
+  span := startSpan(nil, "rapleaf.PersonBySite")
+  stored, err := l.read(span, func() (*StoredPerson, os.Error) {
+    return l.Store.BySite(site, profile_id)
+  })
+  return l.resolve(span, stored, err, ENDPOINT_WEB, personBySiteUrl(site, profile_id), site, profile_id, func() (*RapleafPerson, *Response) {
+    return l.Tag.personBySite(span, l.ApiKey, site, profile_id)
+  })
+}
@@ -0,0 +1,208 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "json"
+  "os"
+  "strconv"
+  "strings"
+  "testing"
+  "time"
+)
+
+func testStoreFile() string {
+  filename := "/tmp/rapleaf_store_test_" + strconv.Itoa(os.Getpid()) + ".log"
+  os.Remove(filename)
+  return filename
+}
+
+func TestFileStore(t *testing.T) {
+  filename := testStoreFile()
+  defer os.Remove(filename)
+  s, err := OpenFileStore(filename)
+  if err != nil {
+    t.Error("Unable to open store: ", err.String())
+    return
+  }
+  first := *USER_WITH_PROFILE_PERSON
+  first.EmailAddress = "john.q.public@gmail.com"
+  second := first
+  second.NumFriends = Known(200)
+  if err = s.Put(&StoredPerson{Person:&first, FetchedAt:100}); err != nil {
+    t.Error("Unable to store person: ", err.String())
+  }
+  s.Put(&StoredPerson{Person:&second, FetchedAt:200, Site:"twitter", ProfileId:"jqpublic"})
+  if err = s.Put(&StoredPerson{Person:&RapleafPerson{}}); err == nil {
+    t.Error("Expected an error storing a person without an id")
+  }
+  s.Close()
+  // reopening rebuilds the indexes from the log
+  if s, err = OpenFileStore(filename); err != nil {
+    t.Error("Unable to reopen store: ", err.String())
+    return
+  }
+  defer s.Close()
+  for _, r := range []*StoredPerson{
+    getStored(t, s.ByRapleafId, "97fc425100000000"),
+    getStored(t, s.ByEmail, "john.q.public@gmail.com"),
+    getStored(t, func(id string) (*StoredPerson, os.Error) { return s.BySite("twitter", id) }, "jqpublic"),
+  } {
+    if r == nil || r.FetchedAt != 200 {
+      t.Errorf("Expected latest version fetched at 200 but found %v", r)
+      continue
+    }
+    testSamePerson(t, &second, r.Person)
+  }
+  if r, _ := s.ByEmail("nobody@example.com"); r != nil {
+    t.Errorf("Expected no person for unknown email but found %v", r)
+  }
+  history, err := s.History("97fc425100000000")
+  if err != nil || len(history) != 2 || history[0].FetchedAt != 100 || history[1].FetchedAt != 200 {
+    t.Errorf("Expected two versions oldest first but found %v (%v)", history, err)
+  }
+  if ids := s.Ids(); len(ids) != 1 || ids[0] != "97fc425100000000" {
+    t.Errorf("Expected one stored id but found %v", ids)
+  }
+}
+
+func getStored(t *testing.T, get func(string) (*StoredPerson, os.Error), key string) *StoredPerson {
+  r, err := get(key)
+  if err != nil {
+    t.Errorf("Unable to read %s from store: %s", key, err.String())
+  }
+  return r
+}
+
+func TestStoreLookup(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  defer closeServerTestFiles(l)
+  filename := testStoreFile()
+  defer os.Remove(filename)
+  s, err := OpenFileStore(filename)
+  if err != nil {
+    t.Error("Unable to open store: ", err.String())
+    return
+  }
+  defer s.Close()
+  lookup := NewStoreLookup(s, API_KEY, 3600)
+  ResetUsage()
+  expected := *USER_WITH_PROFILE_PERSON
+  expected.EmailAddress = "john.q.public@gmail.com"
+  for i := 0; i < 2; i++ {
+    u, r, err := lookup.PersonByEmailWithResponse("john.q.public@gmail.com")
+    if err != nil {
+      t.Error("Unexpected store error: ", err.String())
+    }
+    testSamePerson(t, &expected, u)
+    if (i == 0) != (r != nil) {
+      t.Errorf("Expected a response only from the API but found %v on lookup %d", r, i + 1)
+    }
+  }
+  if server.Calls() != 1 {
+    t.Errorf("Expected the second lookup to be answered from the store but the API saw %d calls", server.Calls())
+  }
+  if u := Usage(); u.CacheHits != 1 || u.CacheMisses != 1 {
+    t.Errorf("Expected 1 cache hit and 1 miss in usage but found %d and %d", u.CacheHits, u.CacheMisses)
+  }
+  // a stale record is refetched and the new version appended
+  s.Put(&StoredPerson{Person:&expected, FetchedAt:time.Seconds() - 7200})
+  if _, r, _ := lookup.PersonByEmailWithResponse("john.q.public@gmail.com"); r == nil || r.Status != 200 {
+    t.Errorf("Expected the refetch's response but found %v", r)
+  }
+  if server.Calls() != 2 {
+    t.Errorf("Expected a stale record to be refetched but the API saw %d calls", server.Calls())
+  }
+  if history, _ := s.History("97fc425100000000"); len(history) != 3 {
+    t.Errorf("Expected 3 stored versions but found %d", len(history))
+  }
+}
+
+// failingStore is a FileStore whose writes fail.
+type failingStore struct {
+  *FileStore
+}
+
+func (s failingStore) Put(r *StoredPerson) os.Error {
+  return os.NewError("disk full")
+}
+
+func TestStoreLookupPutError(t *testing.T) {
+  l, err := serveTestFiles(t)
+  if err != nil {
+    return
+  }
+  defer closeServerTestFiles(l)
+  filename := testStoreFile()
+  defer os.Remove(filename)
+  s, err := OpenFileStore(filename)
+  if err != nil {
+    t.Error("Unable to open store: ", err.String())
+    return
+  }
+  defer s.Close()
+  lookup := NewStoreLookup(failingStore{s}, API_KEY, 3600)
+  u, err := lookup.PersonByEmail("john.q.public@gmail.com")
+  if u == nil || err == nil || err.String() != "disk full" {
+    t.Errorf("Expected the person and the store error but found %v and %v", u, err)
+  }
+}
+
+func TestFileStoreCutRecords(t *testing.T) {
+  filename := testStoreFile()
+  defer os.Remove(filename)
+  s, err := OpenFileStore(filename)
+  if err != nil {
+    t.Error("Unable to open store: ", err.String())
+    return
+  }
+  person := *USER_WITH_PROFILE_PERSON
+  s.Put(&StoredPerson{Person:&person, FetchedAt:100})
+  s.Close()
+  // a record without a person, then a write cut short that is longer
+  // than the next record put
+  cut, _ := json.Marshal(&StoredPerson{Person:&person, FetchedAt:300, Site:"twitter", ProfileId:strings.Repeat("x", 1000)})
+  f, err := os.Open(filename, os.O_WRONLY | os.O_APPEND, 0644)
+  if err != nil {
+    t.Error("Unable to append to store: ", err.String())
+    return
+  }
+  f.WriteString("{\"person\":null,\"fetched_at\":150}\n")
+  f.Write(cut[0:len(cut) - 1])
+  f.Close()
+  if s, err = OpenFileStore(filename); err != nil {
+    t.Error("Unable to reopen store: ", err.String())
+    return
+  }
+  person.NumFriends = Known(200)
+  s.Put(&StoredPerson{Person:&person, FetchedAt:200})
+  s.Close()
+  if s, err = OpenFileStore(filename); err != nil {
+    t.Error("Unable to reopen store after a cut record: ", err.String())
+    return
+  }
+  defer s.Close()
+  if history, _ := s.History("97fc425100000000"); len(history) != 2 || history[1].FetchedAt != 200 {
+    t.Errorf("Expected two versions, the last fetched at 200, but found %v", history)
+  }
+}
@@ -0,0 +1,138 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "sync"
+  "time"
+)
+
+// A Span times one step of a lookup.  Attributes never carry an email or
+// profile id, only the endpoint, the status and the Rapleaf id found.
+type Span interface {
+  SetAttribute(key, value string)
+  End()
+}
+
+// A Tracer starts spans.  Every lookup gets a root span named after the
+// function called, e.g. "rapleaf.PersonByEmail", with children for the
+// HTTP round trip ("rapleaf.http") and XML parsing ("rapleaf.parse").  A
+// StoreLookup adds one for reading its store ("rapleaf.store"), and each
+// wait after a 202 gets one ("rapleaf.poll") holding the retried requests.
+// Adapters to other tracing systems implement this interface.
+type Tracer interface {
+  // Start begins a span; parent is nil for the root span of a lookup.
+  Start(parent Span, name string) Span
+}
+
+type noopSpan struct{}
+
+func (s noopSpan) SetAttribute(key, value string) {}
+
+func (s noopSpan) End() {}
+
+var (
+  tracer Tracer
+  tracerLock sync.RWMutex
+)
+
+// SetTracer installs t for all lookups; nil turns tracing off.
+func SetTracer(t Tracer) {
+  tracerLock.Lock()
+  tracer = t
+  tracerLock.Unlock()
+}
+
+func startSpan(parent Span, name string) Span {
+  tracerLock.RLock()
+  t := tracer
+  tracerLock.RUnlock()
+  if t == nil {
+    return noopSpan{}
+  }
+  if _, ok := parent.(noopSpan); ok {
+    parent = nil
+  }
+  return t.Start(parent, name)
+}
+
+// MemorySpan is a span recorded by a MemoryTracer.  Times are in
+// nanoseconds since the epoch.
+type MemorySpan struct {
+  Name string
+  Parent *MemorySpan
+  Attributes map[string]string
+  StartTime int64
+  EndTime int64
+  tracer *MemoryTracer
+}
+
+// MemoryTracer keeps every finished span in memory, for tests.
+type MemoryTracer struct {
+  finished []*MemorySpan
+  lock sync.Mutex
+}
+
+func NewMemoryTracer() *MemoryTracer {
+  return &MemoryTracer{finished:make([]*MemorySpan, 0, 16)}
+}
+
+func (t *MemoryTracer) Start(parent Span, name string) Span {
+  s := &MemorySpan{
+    Name:name,
+    Attributes:make(map[string]string),
+    StartTime:time.Nanoseconds(),
+    tracer:t,
+  }
+  if p, ok := parent.(*MemorySpan); ok {
+    s.Parent = p
+  }
+  return s
+}
+
+// Finished returns the spans ended so far, in the order they ended.
+func (t *MemoryTracer) Finished() []*MemorySpan {
+  t.lock.Lock()
+  defer t.lock.Unlock()
+  spans := make([]*MemorySpan, len(t.finished))
+  copy(spans, t.finished)
+  return spans
+}
+
+func (t *MemoryTracer) Reset() {
+  t.lock.Lock()
+  t.finished = t.finished[0:0]
+  t.lock.Unlock()
+}
+
+func (s *MemorySpan) SetAttribute(key, value string) {
+  s.Attributes[key] = value
+}
+
+func (s *MemorySpan) End() {
+  s.EndTime = time.Nanoseconds()
+  t := s.tracer
+  t.lock.Lock()
+  defer t.lock.Unlock()
+  if len(t.finished) == cap(t.finished) {
+    spans := make([]*MemorySpan, len(t.finished), 2 * len(t.finished) + 16)
+    copy(spans, t.finished)
+    t.finished = spans
+  }
+  t.finished = t.finished[0:len(t.finished) + 1]
+  t.finished[len(t.finished) - 1] = s
+}
@@ -0,0 +1,163 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "os"
+  "strings"
+  "testing"
+)
+
+func TestTracePersonByEmail(t *testing.T) {
+  l, err := serveTestFiles(t)
+  if err != nil {
+    return
+  }
+  tracer := NewMemoryTracer()
+  SetTracer(tracer)
+  PersonByEmail(API_KEY, "john.q.public@gmail.com")
+  SetTracer(nil)
+  closeServerTestFiles(l)
+  spans := tracer.Finished()
+  if len(spans) != 3 {
+    t.Errorf("Expected 3 spans but found %d", len(spans))
+    return
+  }
+  round_trip, parse, root := spans[0], spans[1], spans[2]
+  if root.Name != "rapleaf.PersonByEmail" || root.Parent != nil {
+    t.Errorf("Expected root span rapleaf.PersonByEmail but found %s", root.Name)
+  }
+  if round_trip.Name != "rapleaf.http" || round_trip.Parent != root {
+    t.Errorf("Expected rapleaf.http child of the root span but found %s", round_trip.Name)
+  }
+  if parse.Name != "rapleaf.parse" || parse.Parent != root {
+    t.Errorf("Expected rapleaf.parse child of the root span but found %s", parse.Name)
+  }
+  if root.Attributes["rapleaf.endpoint"] != ENDPOINT_EMAIL || root.Attributes["http.status_code"] != "200" || root.Attributes["rapleaf.id"] != "97fc425100000000" {
+    t.Errorf("Expected endpoint, status and rapleaf id attributes but found %v", root.Attributes)
+  }
+  for _, span := range spans {
+    if span.EndTime < span.StartTime {
+      t.Errorf("Expected span %s to end after it started", span.Name)
+    }
+    for key, value := range span.Attributes {
+      if strings.Index(value, "@") >= 0 {
+        t.Errorf("Expected no email in span %s but found %s=%s", span.Name, key, value)
+      }
+    }
+  }
+}
+
+func TestTraceNotFound(t *testing.T) {
+  l, err := serveTestFiles(t)
+  if err != nil {
+    return
+  }
+  tracer := NewMemoryTracer()
+  SetTracer(tracer)
+  PersonBySite(API_KEY, "linkedin", "nobody")
+  SetTracer(nil)
+  closeServerTestFiles(l)
+  spans := tracer.Finished()
+  if len(spans) != 2 {
+    t.Errorf("Expected an http and a root span but found %d spans", len(spans))
+    return
+  }
+  root := spans[1]
+  if root.Attributes["http.status_code"] != "404" {
+    t.Errorf("Expected status 404 on the root span but found %v", root.Attributes)
+  }
+  if _, ok := root.Attributes["rapleaf.id"]; ok {
+    t.Errorf("Expected no rapleaf id on a lookup that found nobody but found %v", root.Attributes)
+  }
+}
+
+func TestTracePolling(t *testing.T) {
+  server := NewMockServer(API_KEY)
+  server.Pending = 1
+  server.AddFixture("/v3/person/email/john.q.public@gmail.com", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  tracer := NewMemoryTracer()
+  SetTracer(tracer)
+  SetPolling(2, 1e6)
+  u, r := PersonByEmailWithResponse(API_KEY, "john.q.public@gmail.com")
+  SetPolling(0, 0)
+  SetTracer(nil)
+  closeServerTestFiles(l)
+  if u == nil || r.Attempts != 2 {
+    t.Errorf("Expected the person after two attempts but found %v", r)
+  }
+  spans := tracer.Finished()
+  if len(spans) != 5 {
+    t.Errorf("Expected 5 spans but found %d", len(spans))
+    return
+  }
+  first, retry, poll, root := spans[0], spans[1], spans[2], spans[4]
+  if first.Name != "rapleaf.http" || first.Parent != root || first.Attributes["http.status_code"] != "202" {
+    t.Errorf("Expected a 202 round trip under the root span but found %s %v", first.Name, first.Attributes)
+  }
+  if poll.Name != "rapleaf.poll" || poll.Parent != root || poll.Attributes["rapleaf.poll"] != "1" || poll.Attributes["http.status_code"] != "200" {
+    t.Errorf("Expected the first poll under the root span but found %s %v", poll.Name, poll.Attributes)
+  }
+  if retry.Name != "rapleaf.http" || retry.Parent != poll {
+    t.Errorf("Expected the retried round trip under the poll span but found %s", retry.Name)
+  }
+}
+
+func TestTraceStoreLookup(t *testing.T) {
+  l, err := serveTestFiles(t)
+  if err != nil {
+    return
+  }
+  defer closeServerTestFiles(l)
+  filename := testStoreFile()
+  defer os.Remove(filename)
+  s, err := OpenFileStore(filename)
+  if err != nil {
+    t.Error("Unable to open store: ", err.String())
+    return
+  }
+  defer s.Close()
+  lookup := NewStoreLookup(s, API_KEY, 3600)
+  tracer := NewMemoryTracer()
+  SetTracer(tracer)
+  lookup.PersonByEmail("john.q.public@gmail.com")
+  lookup.PersonByEmail("john.q.public@gmail.com")
+  SetTracer(nil)
+  spans := tracer.Finished()
+  if len(spans) != 6 {
+    t.Errorf("Expected 4 spans for the miss and 2 for the hit but found %d", len(spans))
+    return
+  }
+  miss, miss_root, hit, hit_root := spans[0], spans[3], spans[4], spans[5]
+  if miss.Name != "rapleaf.store" || miss.Parent != miss_root || miss.Attributes["rapleaf.cache"] != "miss" {
+    t.Errorf("Expected a store miss under the first lookup but found %s %v", miss.Name, miss.Attributes)
+  }
+  if spans[1].Name != "rapleaf.http" || spans[1].Parent != miss_root {
+    t.Errorf("Expected the round trip under the first lookup but found %s", spans[1].Name)
+  }
+  if hit.Name != "rapleaf.store" || hit.Parent != hit_root || hit.Attributes["rapleaf.cache"] != "hit" {
+    t.Errorf("Expected a store hit under the second lookup but found %s %v", hit.Name, hit.Attributes)
+  }
+  if hit_root.Name != "rapleaf.PersonByEmail" || hit_root.Attributes["rapleaf.id"] != "97fc425100000000" {
+    t.Errorf("Expected the second lookup to find the stored person but found %s %v", hit_root.Name, hit_root.Attributes)
+  }
+}
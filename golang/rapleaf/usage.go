@@ -0,0 +1,280 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "bytes"
+  "expvar"
+  "fmt"
+  "http"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+const (
+  ENDPOINT_EMAIL = "email"
+  ENDPOINT_WEB = "web"
+  ENDPOINT_GRAPH = "graph"
+  secondsPerDay = 24 * 60 * 60
+  // projections from less than this much of the day are too noisy to use
+  minProjectionSeconds = 15 * 60
+)
+
+// UsageSnapshot is a copy of the usage counters at one point in time.
+// Every lookup that reaches the network counts once, including those whose
+// status was made up locally because the API could not be reached.
+type UsageSnapshot struct {
+  // Since and Taken are in seconds since the epoch
+  Since int64
+  Taken int64
+  Total int64
+  ByEndpoint map[string]int64
+  ByStatus map[int]int64
+  ByCaller map[string]int64
+  // cache outcomes as reported through RecordCacheLookup
+  CacheHits int64
+  CacheMisses int64
+  // Today counts the lookups made since midnight UTC and ProjectedToday
+  // extrapolates it to the end of the day at the rate seen so far.
+  Today int64
+  ProjectedToday int64
+  DailyQuota int64
+}
+
+type usageCounters struct {
+  lock sync.Mutex
+  since int64
+  total int64
+  byEndpoint map[string]int64
+  byStatus map[int]int64
+  byCaller map[string]int64
+  cacheHits int64
+  cacheMisses int64
+  day int64
+  today int64
+  dailyQuota int64
+}
+
+var (
+  usage = newUsageCounters()
+)
+
+type usageVar struct{}
+
+func (v usageVar) String() string {
+  return Usage().String()
+}
+
+func init() {
+  expvar.Publish("rapleaf", usageVar{})
+}
+
+func newUsageCounters() *usageCounters {
+  now := time.Seconds()
+  return &usageCounters{
+    since:now,
+    byEndpoint:make(map[string]int64),
+    byStatus:make(map[int]int64),
+    byCaller:make(map[string]int64),
+    day:now / secondsPerDay,
+  }
+}
+
+func recordUsage(endpoint string, tag CallerTag, code int) {
+  now := time.Seconds()
+  usage.lock.Lock()
+  defer usage.lock.Unlock()
+  if now / secondsPerDay != usage.day {
+    usage.day = now / secondsPerDay
+    usage.today = 0
+  }
+  usage.total++
+  usage.today++
+  usage.byEndpoint[endpoint]++
+  usage.byStatus[code]++
+  if len(tag) > 0 {
+    usage.byCaller[string(tag)]++
+  }
+}
+
+// RecordCacheLookup counts a lookup answered, or not, from a cache kept in
+// front of the API, such as the store of a StoreLookup or the cache in
+// rapleaf-proxy.
+func RecordCacheLookup(hit bool) {
+  usage.lock.Lock()
+  defer usage.lock.Unlock()
+  if hit {
+    usage.cacheHits++
+  } else {
+    usage.cacheMisses++
+  }
+}
+
+// SetDailyQuota sets the number of lookups per day the API key allows, so
+// that UsageSnapshot.OverQuota can warn before the API starts answering 403.
+func SetDailyQuota(n int64) {
+  usage.lock.Lock()
+  usage.dailyQuota = n
+  usage.lock.Unlock()
+}
+
+// ResetUsage zeroes the counters; the daily quota is kept.
+func ResetUsage() {
+  fresh := newUsageCounters()
+  usage.lock.Lock()
+  defer usage.lock.Unlock()
+  usage.since = fresh.since
+  usage.total = 0
+  usage.byEndpoint = fresh.byEndpoint
+  usage.byStatus = fresh.byStatus
+  usage.byCaller = fresh.byCaller
+  usage.cacheHits = 0
+  usage.cacheMisses = 0
+  usage.day = fresh.day
+  usage.today = 0
+}
+
+func Usage() *UsageSnapshot {
+  now := time.Seconds()
+  u := usage
+  u.lock.Lock()
+  defer u.lock.Unlock()
+  s := &UsageSnapshot{
+    Since:u.since,
+    Taken:now,
+    Total:u.total,
+    ByEndpoint:make(map[string]int64),
+    ByStatus:make(map[int]int64),
+    ByCaller:make(map[string]int64),
+    CacheHits:u.cacheHits,
+    CacheMisses:u.cacheMisses,
+    DailyQuota:u.dailyQuota,
+  }
+  for k, v := range u.byEndpoint {
+    s.ByEndpoint[k] = v
+  }
+  for k, v := range u.byStatus {
+    s.ByStatus[k] = v
+  }
+  for k, v := range u.byCaller {
+    s.ByCaller[k] = v
+  }
+  if now / secondsPerDay == u.day {
+    s.Today = u.today
+  }
+  start := (now / secondsPerDay) * secondsPerDay
+  if u.since > start {
+    start = u.since
+  }
+  elapsed := now - start
+  if elapsed < minProjectionSeconds {
+    elapsed = minProjectionSeconds
+  }
+  remaining := (now / secondsPerDay + 1) * secondsPerDay - now
+  s.ProjectedToday = s.Today + s.Today * remaining / elapsed
+  return s
+}
+
+// OverQuota reports whether today's lookups are on course to exceed the
+// daily quota.
+func (s *UsageSnapshot) OverQuota() bool {
+  return s.DailyQuota > 0 && s.ProjectedToday > s.DailyQuota
+}
+
+func sortedInt64Keys(m map[string]int64) []string {
+  keys := make([]string, len(m))
+  i := 0
+  for k, _ := range m {
+    keys[i] = k
+    i++
+  }
+  sort.SortStrings(keys)
+  return keys
+}
+
+func sortedStatuses(m map[int]int64) []int {
+  keys := make([]int, len(m))
+  i := 0
+  for k, _ := range m {
+    keys[i] = k
+    i++
+  }
+  sort.SortInts(keys)
+  return keys
+}
+
+func jsonInt64Map(m map[string]int64) string {
+  keys := sortedInt64Keys(m)
+  arr := make([]string, len(keys))
+  for i, k := range keys {
+    arr[i] = strconv.Quote(k) + ":" + strconv.Itoa64(m[k])
+  }
+  return "{" + strings.Join(arr, ",") + "}"
+}
+
+// String renders the snapshot as JSON, which is what expvar serves it as
+// under "rapleaf" on /debug/vars.
+func (s *UsageSnapshot) String() string {
+  statuses := sortedStatuses(s.ByStatus)
+  arr := make([]string, len(statuses))
+  for i, code := range statuses {
+    arr[i] = "\"" + strconv.Itoa(code) + "\":" + strconv.Itoa64(s.ByStatus[code])
+  }
+  return fmt.Sprintf("{\"since\":%d,\"taken\":%d,\"total\":%d,\"by_endpoint\":%s,\"by_status\":{%s},\"by_caller\":%s,\"cache_hits\":%d,\"cache_misses\":%d,\"today\":%d,\"projected_today\":%d,\"daily_quota\":%d,\"over_quota\":%t}",
+    s.Since, s.Taken, s.Total,
+    jsonInt64Map(s.ByEndpoint), strings.Join(arr, ","), jsonInt64Map(s.ByCaller),
+    s.CacheHits, s.CacheMisses, s.Today, s.ProjectedToday, s.DailyQuota, s.OverQuota())
+}
+
+// PrometheusText renders the snapshot in the Prometheus text exposition
+// format.
+func (s *UsageSnapshot) PrometheusText() string {
+  b := bytes.NewBuffer(nil)
+  fmt.Fprintf(b, "# TYPE rapleaf_requests_total counter\n")
+  for _, k := range sortedInt64Keys(s.ByEndpoint) {
+    fmt.Fprintf(b, "rapleaf_requests_total{endpoint=%q} %d\n", k, s.ByEndpoint[k])
+  }
+  fmt.Fprintf(b, "# TYPE rapleaf_responses_total counter\n")
+  for _, code := range sortedStatuses(s.ByStatus) {
+    fmt.Fprintf(b, "rapleaf_responses_total{status=\"%d\"} %d\n", code, s.ByStatus[code])
+  }
+  fmt.Fprintf(b, "# TYPE rapleaf_caller_requests_total counter\n")
+  for _, k := range sortedInt64Keys(s.ByCaller) {
+    fmt.Fprintf(b, "rapleaf_caller_requests_total{caller=%q} %d\n", k, s.ByCaller[k])
+  }
+  fmt.Fprintf(b, "# TYPE rapleaf_cache_lookups_total counter\n")
+  fmt.Fprintf(b, "rapleaf_cache_lookups_total{outcome=\"hit\"} %d\n", s.CacheHits)
+  fmt.Fprintf(b, "rapleaf_cache_lookups_total{outcome=\"miss\"} %d\n", s.CacheMisses)
+  fmt.Fprintf(b, "# TYPE rapleaf_requests_today gauge\n")
+  fmt.Fprintf(b, "rapleaf_requests_today %d\n", s.Today)
+  fmt.Fprintf(b, "# TYPE rapleaf_requests_projected_today gauge\n")
+  fmt.Fprintf(b, "rapleaf_requests_projected_today %d\n", s.ProjectedToday)
+  fmt.Fprintf(b, "# TYPE rapleaf_daily_quota gauge\n")
+  fmt.Fprintf(b, "rapleaf_daily_quota %d\n", s.DailyQuota)
+  return b.String()
+}
+
+// UsageHandler serves Usage().PrometheusText(), e.g.
+//   http.Handle("/metrics", http.HandlerFunc(rapleaf.UsageHandler))
+func UsageHandler(conn *http.Conn, req *http.Request) {
+  conn.SetHeader("Content-Type", "text/plain; version=0.0.4")
+  conn.WriteHeader(http.StatusOK)
+  conn.Write([]byte(Usage().PrometheusText()))
+}
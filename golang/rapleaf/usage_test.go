@@ -0,0 +1,71 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "http"
+  "strings"
+  "testing"
+)
+
+func TestUsage(t *testing.T) {
+  l, err := serveTestFiles(t)
+  if err != nil {
+    return
+  }
+  ResetUsage()
+  PersonXmlByEmail(API_KEY, "john.q.public@gmail.com")
+  PersonXmlByEmail(API_KEY, "nobody@gmail.com")
+  CallerTag("reports").PersonByRapleafId(API_KEY, "97fc425100000000")
+  RecordCacheLookup(true)
+  RecordCacheLookup(false)
+  closeServerTestFiles(l)
+  s := Usage()
+  if s.Total != 3 || s.Today != 3 {
+    t.Errorf("Expected 3 lookups in total and today but found %d and %d", s.Total, s.Today)
+  }
+  if s.ByEndpoint[ENDPOINT_EMAIL] != 2 || s.ByEndpoint[ENDPOINT_WEB] != 1 || s.ByEndpoint[ENDPOINT_GRAPH] != 0 {
+    t.Errorf("Expected 2 email and 1 web lookups but found %v", s.ByEndpoint)
+  }
+  if s.ByStatus[http.StatusOK] != 2 || s.ByStatus[http.StatusNotFound] != 1 {
+    t.Errorf("Expected 2 found and 1 not found but found %v", s.ByStatus)
+  }
+  if len(s.ByCaller) != 1 || s.ByCaller["reports"] != 1 {
+    t.Errorf("Expected 1 lookup by caller reports but found %v", s.ByCaller)
+  }
+  if s.CacheHits != 1 || s.CacheMisses != 1 {
+    t.Errorf("Expected 1 cache hit and 1 miss but found %d and %d", s.CacheHits, s.CacheMisses)
+  }
+  if s.ProjectedToday < s.Today {
+    t.Errorf("Expected projection %d to be at least today's count %d", s.ProjectedToday, s.Today)
+  }
+  if strings.Index(s.PrometheusText(), "rapleaf_requests_total{endpoint=\"email\"} 2\n") < 0 {
+    t.Errorf("Expected email request count in metrics but found:\n%s", s.PrometheusText())
+  }
+}
+
+func TestUsageOverQuota(t *testing.T) {
+  s := &UsageSnapshot{Today:10, ProjectedToday:120, DailyQuota:100}
+  if !s.OverQuota() {
+    t.Error("Expected projection of 120 to be over a quota of 100")
+  }
+  s.DailyQuota = 0
+  if s.OverQuota() {
+    t.Error("Expected no quota warning without a quota")
+  }
+}
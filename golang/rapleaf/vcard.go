@@ -0,0 +1,110 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "bytes"
+  "strings"
+)
+
+// vcardEscape escapes a text value; structured values escape each
+// component and join them with ';'.
+func vcardEscape(s string) string {
+  b := bytes.NewBuffer(nil)
+  for i := 0; i < len(s); i++ {
+    switch s[i] {
+    case '\\', ',', ';':
+      b.WriteByte('\\')
+      b.WriteByte(s[i])
+    case '\n':
+      b.WriteString("\\n")
+    case '\r':
+      // dropped; "\r\n" is written as "\n"
+    default:
+      b.WriteByte(s[i])
+    }
+  }
+  return b.String()
+}
+
+// writeVCardLine folds lines longer than 75 octets as RFC 6350 requires,
+// taking care not to split a UTF-8 sequence.
+func writeVCardLine(b *bytes.Buffer, line string) {
+  limit := 75
+  for len(line) > limit {
+    n := limit
+    for n > 1 && line[n] & 0xC0 == 0x80 {
+      n--
+    }
+    b.WriteString(line[0:n] + "\r\n ")
+    line = line[n:]
+    // the leading space of a continuation counts against its length
+    limit = 74
+  }
+  b.WriteString(line + "\r\n")
+}
+
+// VCard renders the person as a vCard 4.0 record.  FN falls back to the
+// email address when the name is unknown; ORG and TITLE come from the first
+// occupation and ADR from the parsed location.  Each membership that
+// exists and has a profile gives both a URL and an X-SOCIALPROFILE entry,
+// since address books understand one or the other.
+func (p *RapleafPerson) VCard() string {
+  b := bytes.NewBuffer(nil)
+  writeVCardLine(b, "BEGIN:VCARD")
+  writeVCardLine(b, "VERSION:4.0")
+  name := p.Name
+  if len(name) == 0 {
+    name = p.EmailAddress
+  }
+  writeVCardLine(b, "FN:" + vcardEscape(name))
+  if len(p.Id) > 0 {
+    writeVCardLine(b, "UID:urn:rapleaf:" + vcardEscape(p.Id))
+  }
+  switch p.Gender {
+  case GENDER_MALE:
+    writeVCardLine(b, "GENDER:M")
+  case GENDER_FEMALE:
+    writeVCardLine(b, "GENDER:F")
+  }
+  if len(p.EmailAddress) > 0 {
+    writeVCardLine(b, "EMAIL:" + vcardEscape(p.EmailAddress))
+  }
+  if len(p.Occupations) > 0 {
+    if o := p.Occupations[0]; len(o.Company) > 0 {
+      writeVCardLine(b, "ORG:" + vcardEscape(o.Company))
+    }
+    if o := p.Occupations[0]; len(o.JobTitle) > 0 {
+      writeVCardLine(b, "TITLE:" + vcardEscape(o.JobTitle))
+    }
+  }
+  if len(p.Location) > 0 {
+    l := p.ParsedLocation()
+    writeVCardLine(b, "ADR;LABEL=\"" + strings.Replace(l.Raw, "\"", "'", -1) + "\":;;;" +
+      vcardEscape(l.City) + ";" + vcardEscape(l.Region) + ";;" + vcardEscape(l.Country))
+  }
+  for _, m := range p.Memberships {
+    if m.Exists != "true" || len(m.ProfileUrl) == 0 {
+      continue
+    }
+    site := NormalizeSite(m.Site).ShortName()
+    writeVCardLine(b, "URL;TYPE=" + site + ":" + m.ProfileUrl)
+    writeVCardLine(b, "X-SOCIALPROFILE;TYPE=" + site + ":" + m.ProfileUrl)
+  }
+  writeVCardLine(b, "END:VCARD")
+  return b.String()
+}
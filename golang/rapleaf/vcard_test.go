@@ -0,0 +1,70 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "strings"
+  "testing"
+)
+
+func TestVCard(t *testing.T) {
+  p := *USER_WITH_PROFILE_PERSON
+  p.EmailAddress = "john.q.public@gmail.com"
+  card := p.VCard()
+  for _, line := range []string{
+    "BEGIN:VCARD",
+    "VERSION:4.0",
+    "FN:John Q Public",
+    "UID:urn:rapleaf:97fc425100000000",
+    "GENDER:M",
+    "EMAIL:john.q.public@gmail.com",
+    "ORG:Apple",
+    "TITLE:Software Developer",
+    "ADR;LABEL=\"Albuquerque, New Mexico, United States\":;;;Albuquerque;New Mexico;;United States",
+    "URL;TYPE=twitter:http://twitter.com/johnqpublic",
+    "X-SOCIALPROFILE;TYPE=twitter:http://twitter.com/johnqpublic",
+    "X-SOCIALPROFILE;TYPE=tagged:http://www.tagged.com/profile.html?uid=5378192615",
+    "END:VCARD",
+  } {
+    if strings.Index(card, "\r\n" + line + "\r\n") < 0 && !strings.HasPrefix(card, line + "\r\n") {
+      t.Errorf("Expected line %s in vCard:\n%s", line, card)
+    }
+  }
+  // facebook exists but has no profile url
+  if strings.Index(card, "TYPE=facebook") >= 0 || strings.Index(card, "TYPE=bebo") >= 0 {
+    t.Errorf("Expected only memberships with profiles in vCard:\n%s", card)
+  }
+}
+
+func TestVCardEscapingAndFolding(t *testing.T) {
+  p := &RapleafPerson{
+    Name:"Public, John\\; Q\\" + strings.Repeat("x", 100),
+    Occupations:[]*RapleafOccupation{&RapleafOccupation{Company:"Smith, Sons & Co"}},
+  }
+  card := p.VCard()
+  for _, line := range strings.Split(card, "\r\n", -1) {
+    if len(line) > 75 {
+      t.Errorf("Expected folded lines of at most 75 octets but found %d: %s", len(line), line)
+    }
+  }
+  unfolded := strings.Replace(card, "\r\n ", "", -1)
+  if strings.Index(unfolded, "\r\nFN:Public\\, John\\\\\\; Q\\\\" + strings.Repeat("x", 100) + "\r\n") < 0 ||
+      strings.Index(unfolded, "\r\nORG:Smith\\, Sons & Co\r\n") < 0 {
+    t.Errorf("Expected escaped values in vCard:\n%s", card)
+  }
+}
@@ -0,0 +1,118 @@
+package rapleaf
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  "http"
+  "os"
+  "strconv"
+  "sync"
+)
+
+// An ApiVersion knows the request URLs of one version of the Rapleaf API
+// and how to read its person responses into the common model.  base is
+// "http://host:port" with no trailing slash, site is a short name such as
+// "linkedin", and every other argument is unescaped.
+//
+// AcceptsContentType is given the lowercased media type of a 200 response,
+// without parameters.  A 200 with no Content-Type at all is refused before
+// it is asked.
+type ApiVersion interface {
+  Name() string
+  PersonByEmailUrl(base, email_address string) string
+  PersonBySiteUrl(base, site, profile_id string) string
+  GraphUrl(base, email_or_rapleaf_id string, n int) string
+  AcceptsContentType(endpoint, media_type string) bool
+  DecodePerson(text string, options ParseOptions) (*RapleafPerson, []*ParseWarning, os.Error)
+}
+
+// v3 serves persons as XML; the graph endpoint stayed at v2.
+type v3Api struct{}
+
+func (v v3Api) Name() string {
+  return "v3"
+}
+
+func (v v3Api) PersonByEmailUrl(base, email_address string) string {
+  return base + "/v3/person/email/" + http.URLEscape(email_address)
+}
+
+func (v v3Api) PersonBySiteUrl(base, site, profile_id string) string {
+  return base + "/v3/person/web/" + http.URLEscape(site) + "/" + http.URLEscape(profile_id)
+}
+
+func (v v3Api) GraphUrl(base, email_or_rapleaf_id string, n int) string {
+  return base + "/v2/graph/" + http.URLEscape(email_or_rapleaf_id) + "?n=" + strconv.Itoa(n)
+}
+
+func (v v3Api) AcceptsContentType(endpoint, media_type string) bool {
+  if endpoint == ENDPOINT_GRAPH {
+    return media_type == "text/plain"
+  }
+  return media_type == "application/xml" || media_type == "text/xml"
+}
+
+func (v v3Api) DecodePerson(text string, options ParseOptions) (*RapleafPerson, []*ParseWarning, os.Error) {
+  return RapleafPersonFromStringWith(text, options)
+}
+
+var (
+  V3 ApiVersion = v3Api{}
+  apiVersions = map[string]ApiVersion{"v3":V3}
+  apiVersion = V3
+  apiVersionLock sync.RWMutex
+)
+
+// RegisterApiVersion makes v available to ApiVersionNamed.
+func RegisterApiVersion(v ApiVersion) {
+  apiVersionLock.Lock()
+  apiVersions[v.Name()] = v
+  apiVersionLock.Unlock()
+}
+
+func ApiVersionNamed(name string) (ApiVersion, bool) {
+  apiVersionLock.RLock()
+  defer apiVersionLock.RUnlock()
+  v, ok := apiVersions[name]
+  return v, ok
+}
+
+// SetApiVersion selects the version used by all lookups; nil restores V3.
+func SetApiVersion(v ApiVersion) {
+  if v == nil {
+    v = V3
+  }
+  apiVersionLock.Lock()
+  apiVersion = v
+  apiVersionLock.Unlock()
+}
+
+func currentApiVersion() ApiVersion {
+  apiVersionLock.RLock()
+  defer apiVersionLock.RUnlock()
+  return apiVersion
+}
+
+func apiBase() string {
+  return "http://" + rapleaf_host + ":" + rapleaf_port
+}
+
+// DecodePerson reads the text of a person response, such as that returned
+// by PersonXmlByEmail, with the current API version.
+func DecodePerson(text string, options ParseOptions) (*RapleafPerson, []*ParseWarning, os.Error) {
+  return currentApiVersion().DecodePerson(text, options)
+}
@@ -0,0 +1,93 @@
+package rapleaf_test
+
+/*
+ * Copyright 2010 Aalok Shah (aalok@shah.ws)
+ * 
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * 
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * 
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+import (
+  . "rapleaf"
+  "os"
+  "testing"
+)
+
+// testApiVersion moves persons under /v9 and tags what it decodes, to
+// show lookups go through the selected version.
+type testApiVersion struct{}
+
+func (v testApiVersion) Name() string {
+  return "v9"
+}
+
+func (v testApiVersion) PersonByEmailUrl(base, email_address string) string {
+  return base + "/v9/people/" + email_address
+}
+
+func (v testApiVersion) PersonBySiteUrl(base, site, profile_id string) string {
+  return base + "/v9/people/" + site + "/" + profile_id
+}
+
+func (v testApiVersion) GraphUrl(base, email_or_rapleaf_id string, n int) string {
+  return V3.GraphUrl(base, email_or_rapleaf_id, n)
+}
+
+func (v testApiVersion) AcceptsContentType(endpoint, media_type string) bool {
+  return V3.AcceptsContentType(endpoint, media_type)
+}
+
+func (v testApiVersion) DecodePerson(text string, options ParseOptions) (*RapleafPerson, []*ParseWarning, os.Error) {
+  u, warnings, err := V3.DecodePerson(text, options)
+  if u != nil {
+    u.Name = "v9 " + u.Name
+  }
+  return u, warnings, err
+}
+
+func TestV3Urls(t *testing.T) {
+  base := "http://api.rapleaf.com:80"
+  if u := V3.PersonByEmailUrl(base, "john q@gmail.com"); u != base + "/v3/person/email/john+q%40gmail.com" && u != base + "/v3/person/email/john%20q%40gmail.com" {
+    t.Errorf("Unexpected v3 email url %s", u)
+  }
+  if u := V3.PersonBySiteUrl(base, "rapleaf", "97fc425100000000"); u != base + "/v3/person/web/rapleaf/97fc425100000000" {
+    t.Errorf("Unexpected v3 site url %s", u)
+  }
+  if u := V3.GraphUrl(base, "97fc425100000000", 2); u != base + "/v2/graph/97fc425100000000?n=2" {
+    t.Errorf("Unexpected v3 graph url %s", u)
+  }
+}
+
+func TestSetApiVersion(t *testing.T) {
+  RegisterApiVersion(testApiVersion{})
+  v, ok := ApiVersionNamed("v9")
+  if !ok {
+    t.Error("Expected a registered version to be found by name")
+    return
+  }
+  if _, ok = ApiVersionNamed("v1"); ok {
+    t.Error("Expected no version named v1")
+  }
+  server := NewMockServer(API_KEY)
+  server.AddFixture("/v9/people/john.q.public@gmail.com", "application/xml;charset=UTF-8", USER_WITH_PROFILE_XML)
+  l, err := serveTestHandler(t, server)
+  if err != nil {
+    return
+  }
+  SetApiVersion(v)
+  u := PersonByEmail(API_KEY, "john.q.public@gmail.com")
+  SetApiVersion(nil)
+  closeServerTestFiles(l)
+  if u == nil || u.Name != "v9 John Q Public" {
+    t.Errorf("Expected the lookup to go through the selected version but found %v", u)
+  }
+}